@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,9 +11,20 @@ import (
 	"optimize-hpc-nic/internal/monitor"
 	"optimize-hpc-nic/internal/nic"
 	"optimize-hpc-nic/internal/ringbuffer"
+	"optimize-hpc-nic/internal/state"
 )
 
+// exitChangesPending is the plan mode exit status when -s would change
+// settings, so a rollout can be gated on it
+const exitChangesPending = 3
+
 func main() {
+	os.Exit(run())
+}
+
+// run executes the selected mode and returns the exit status, so deferred
+// cleanup such as closing the log runs before the process exits
+func run() int {
 	// Parse command-line arguments
 	cfg := config.ParseFlags()
 
@@ -26,33 +38,77 @@ func main() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
+	nicMgr, err := nic.NewManager(cfg, log)
+	if err != nil {
+		log.Error("Failed to initialize NIC manager: %v", err)
+		return 1
+	}
+
+	// Fail before changing anything if the original settings cannot be kept
+	if cfg.Mode == config.ModeSet || cfg.Mode == config.ModeMonitor {
+		if cfg.StateFile == "" {
+			log.Info("Not recording original settings: they are not this host's with -replay or -root")
+		} else if err := state.CheckWritable(cfg.StateFile); err != nil {
+			log.Error("Cannot record original settings in %s: %v (choose another file with -state)", cfg.StateFile, err)
+			return 1
+		}
+	}
+
+	// Cancel discovery and monitoring on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-sigs
+		log.Info("Received shutdown signal, stopping service")
+		cancel()
+	}()
+
 	// Execute mode-specific operations
 	switch cfg.Mode {
 	case config.ModeMonitor:
 		log.Info("Starting monitoring mode with interval: %d seconds", cfg.MonitorInterval)
-		nicMgr := nic.NewManager(cfg.MinSpeed, log)
 		monitorService := monitor.New(nicMgr, cfg, log)
-		go func() {
-			<-sigs
-			log.Info("Received shutdown signal, stopping service")
-			monitorService.Stop()
-		}()
-		monitorService.Start()
+		monitorService.Start(ctx)
 
 	case config.ModeSet:
 		log.Info("Configuring ring buffers for high-speed NICs")
-		nicMgr := nic.NewManager(cfg.MinSpeed, log)
 		optimizer := ringbuffer.New(nicMgr, log, cfg)
-		optimizer.OptimizeAll(true)
+		optimizer.OptimizeAll(ctx, true)
 
-	case config.ModeQuery:
-		nicManager := nic.NewManager(cfg.MinSpeed, log)
-		nics, err := nicManager.GetHighSpeedNICs()
+	case config.ModePlan:
+		optimizer := ringbuffer.New(nicMgr, log, cfg)
+		pending, err := optimizer.Plan(ctx)
 		if err != nil {
+			log.Error("Failed to plan changes: %v", err)
+			return 1
+		}
+		if pending > 0 {
+			log.Info("Changes pending on %d NICs", pending)
+			return exitChangesPending
+		}
+
+	case config.ModeRestore:
+		log.Info("Restoring original settings from %s", cfg.StateFile)
+		optimizer := ringbuffer.New(nicMgr, log, cfg)
+		if err := optimizer.Restore(ctx); err != nil {
+			log.Error("Failed to restore NICs: %v", err)
+			return 1
+		}
+
+	case config.ModeInventory:
+		optimizer := ringbuffer.New(nicMgr, log, cfg)
+		if err := optimizer.Inventory(ctx); err != nil {
 			log.Error("Failed to get NICs: %v", err)
-			os.Exit(1)
+			return 1
 		}
 
-		ringbuffer.DisplayFormattedResults(nics)
+	case config.ModeQuery:
+		optimizer := ringbuffer.New(nicMgr, log, cfg)
+		if err := optimizer.Query(ctx); err != nil {
+			log.Error("Failed to get NICs: %v", err)
+			return 1
+		}
 	}
+
+	return 0
 }
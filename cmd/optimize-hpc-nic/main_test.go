@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"optimize-hpc-nic/pkg/system"
+)
+
+// TestMain runs the tool itself when the test binary is started by
+// runTool, so its exit status can be checked
+func TestMain(m *testing.M) {
+	if os.Getenv("OPTIMIZE_HPC_NIC_RUN_MAIN") == "1" {
+		os.Args = append([]string{"optimize-hpc-nic"}, os.Args[1:]...)
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// runTool runs the tool with args and returns its exit status
+func runTool(t *testing.T, args ...string) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "OPTIMIZE_HPC_NIC_RUN_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		t.Logf("output:\n%s", out)
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("running the tool: %v", err)
+	}
+	return 0
+}
+
+// ringOutput returns ethtool -g output with the given current sizes and a
+// pre-set maximum of 8192
+func ringOutput(cur int) string {
+	return fmt.Sprintf("Ring parameters for eth0:\nPre-set maximums:\nRX:\t\t8192\nTX:\t\t8192\n"+
+		"Current hardware settings:\nRX:\t\t%d\nTX:\t\t%d\n", cur, cur)
+}
+
+func TestPlanExitStatus(t *testing.T) {
+	// A sysfs tree with a single physical NIC
+	root := t.TempDir()
+	device := filepath.Join(root, "sys/devices/pci0000:00/0000:3b:00.0")
+	if err := os.MkdirAll(filepath.Join(device, "net/eth0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(device, "net/eth0/type"), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../0000:3b:00.0", filepath.Join(device, "net/eth0/device")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "sys/class/net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../devices/pci0000:00/0000:3b:00.0/net/eth0", filepath.Join(root, "sys/class/net/eth0")); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(policy, []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	speed := system.FixtureEntry{Command: "ethtool", Args: []string{"eth0"}, Output: "Settings for eth0:\n\tSpeed: 400000Mb/s\n"}
+	tests := []struct {
+		name  string
+		rings []system.FixtureEntry
+		want  int
+	}{
+		{
+			name:  "changes pending",
+			rings: []system.FixtureEntry{{Command: "ethtool", Args: []string{"-g", "eth0"}, Output: ringOutput(1024)}},
+			want:  exitChangesPending,
+		},
+		{
+			name:  "up to date",
+			rings: []system.FixtureEntry{{Command: "ethtool", Args: []string{"-g", "eth0"}, Output: ringOutput(8192)}},
+			want:  0,
+		},
+		{
+			// A NIC whose rings cannot be read is never reported up to date
+			name: "not evaluated",
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fixture := &system.Fixture{Commands: append([]system.FixtureEntry{speed}, tt.rings...)}
+			replay := filepath.Join(dir, "fixture.json")
+			if err := fixture.Save(replay); err != nil {
+				t.Fatal(err)
+			}
+
+			got := runTool(t, "-plan", "-root", root, "-replay", replay, "-config", policy,
+				"-log", filepath.Join(dir, "test.log"))
+			if got != tt.want {
+				t.Errorf("exit status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Command sysfs-snapshot copies the parts of a node's sysfs that
+// optimize-hpc-nic reads into a directory, which can then be passed to
+// optimize-hpc-nic -root (usually together with an ethtool -replay fixture)
+// to reproduce the node's discovery elsewhere.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"optimize-hpc-nic/pkg/system"
+)
+
+// snapshotPaths lists the host paths to copy and how many directory levels
+// to descend into each. Symlinks are followed, so the device directories of
+// /sys/class/net entries are copied with their PCI parents.
+var snapshotPaths = []struct {
+	path  string
+	depth int
+}{
+	{"/sys/class/net", 3},
+	{"/sys/bus/pci/devices", 2},
+	{"/sys/class/infiniband", 5},
+	{"/sys/devices/system/cpu/online", 0},
+	{"/sys/module/ib_ipoib/parameters", 1},
+}
+
+func main() {
+	output := flag.String("o", "", "Directory to write the snapshot to (required)")
+	root := flag.String("root", "/", "Root directory of the host's /sys")
+	verbose := flag.Bool("v", false, "List the attributes the host cannot read")
+	flag.Parse()
+
+	if *output == "" {
+		fmt.Fprintln(os.Stderr, "sysfs-snapshot: -o is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fs := system.NewFS(*root)
+	failed := false
+	unreadable := 0
+	for _, p := range snapshotPaths {
+		if !fs.Exists(p.path) {
+			// e.g. /sys/class/infiniband on hosts without RDMA devices
+			continue
+		}
+		skipped, err := fs.Snapshot(*output, p.path, p.depth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to snapshot %s:\n%v\n", p.path, err)
+			failed = true
+		}
+		unreadable += len(skipped)
+		if *verbose {
+			for _, name := range skipped {
+				fmt.Printf("Left out %s: the host cannot read it\n", name)
+			}
+		}
+	}
+
+	if failed {
+		fmt.Fprintf(os.Stderr, "Snapshot in %s is incomplete\n", *output)
+		os.Exit(1)
+	}
+	fmt.Printf("Snapshot written to %s (%d attributes the host cannot read left out)\n", *output, unreadable)
+}
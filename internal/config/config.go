@@ -1,23 +1,40 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"os"
 )
 
 const (
 	// Mode constants
-	ModeQuery   = "query"
-	ModeSet     = "set"
-	ModeMonitor = "monitor"
+	ModeQuery     = "query"
+	ModeSet       = "set"
+	ModeMonitor   = "monitor"
+	ModeInventory = "inventory"
+	ModePlan      = "plan"
+	ModeRestore   = "restore"
 
 	// Default values
 	DefaultMinSpeed        = 200000 // 200G in Mbps
 	DefaultMonitorInterval = 300    // seconds
 	DefaultMaxWorkers      = 5
 	DefaultLogFile         = "/var/log/optimize-hpc-nic/optimize-hpc-nic.log"
-	DefaultLogMaxSize      = 50     // MB
+	DefaultLogMaxSize      = 50 // MB
 	DefaultLogMaxBackups   = 3
-	DefaultLogMaxAge       = 28     // days
+	DefaultLogMaxAge       = 28 // days
+	DefaultBackend         = "auto"
+	DefaultConfigFile      = "/etc/optimize-hpc-nic/config.json"
+	DefaultRoot            = "/"
+	DefaultCommandTimeout  = 10 // seconds
+	DefaultNICTimeout      = 30 // seconds
+	DefaultSetRetries      = 3
+	DefaultRetryBackoff    = 500 // milliseconds, doubled after every retry
+	DefaultStateFile       = "/var/lib/optimize-hpc-nic/state.json"
+	DefaultMaxDisruptions  = 1
+	DefaultSettleDelay     = 5  // seconds
+	DefaultLinkTimeout     = 60 // seconds
 )
 
 // Config holds all configuration options
@@ -29,6 +46,45 @@ type Config struct {
 	MaxWorkers      int
 	Verbose         bool
 
+	// Ethtool backend: auto, netlink or exec
+	Backend string
+
+	// Command fixtures: record real ethtool invocations or replay them
+	RecordFile string
+	ReplayFile string
+
+	// Seconds between the two counter samples taken in query mode (0 = one sample)
+	StatsInterval int
+
+	// Seconds before a single ethtool command or netlink request is abandoned,
+	// and before a NIC whose discovery has not finished is marked unresponsive
+	CommandTimeout int
+	NICTimeout     int
+
+	// Retries of a ring change failing with EBUSY, and the delay before the
+	// first retry in milliseconds
+	SetRetries   int
+	RetryBackoff int
+
+	// NICs changed at the same time (0 = no limit), seconds to wait after a
+	// changed NIC's link is back before the next one is changed, and seconds
+	// a changed NIC may take to come back (0 = no limit)
+	MaxDisruptions int
+	SettleDelay    int
+	LinkTimeout    int
+
+	// Original settings of every changed NIC, used by restore mode; empty
+	// when they are not recorded
+	StateFile string
+
+	// Root under which the host's sysfs is read ("/" on the host,
+	// e.g. /host in a container or a snapshot directory)
+	Root string
+
+	// Desired NIC settings from the config file
+	ConfigFile string
+	Policy     Policy
+
 	// Logging settings
 	LogFile       string
 	LogMaxSize    int
@@ -47,23 +103,57 @@ func ParseFlags() *Config {
 		LogMaxSize:      DefaultLogMaxSize,
 		LogMaxBackups:   DefaultLogMaxBackups,
 		LogMaxAge:       DefaultLogMaxAge,
+		Backend:         DefaultBackend,
+		ConfigFile:      DefaultConfigFile,
+		Root:            DefaultRoot,
+		CommandTimeout:  DefaultCommandTimeout,
+		NICTimeout:      DefaultNICTimeout,
+		SetRetries:      DefaultSetRetries,
+		RetryBackoff:    DefaultRetryBackoff,
+		StateFile:       DefaultStateFile,
+		MaxDisruptions:  DefaultMaxDisruptions,
+		SettleDelay:     DefaultSettleDelay,
+		LinkTimeout:     DefaultLinkTimeout,
 	}
 
 	// Define flags
 	setMode := flag.Bool("s", false, "Set ring buffer mode (optimize NICs)")
 	monitorMode := flag.Bool("m", false, "Monitor ring buffer settings continuously")
 	queryMode := flag.Bool("q", false, "Query current ring buffer settings (default)")
+	restoreMode := flag.Bool("restore", false, "Restore mode: put every changed NIC back to its original settings")
+	planMode := flag.Bool("plan", false, "Plan mode: print the changes -s would make without applying them")
+	inventoryMode := flag.Bool("inventory", false, "Print driver, firmware and board inventory of high-speed NICs")
 	flag.IntVar(&cfg.MonitorInterval, "interval", DefaultMonitorInterval, "Monitor interval in seconds")
 	flag.IntVar(&cfg.MinSpeed, "min-speed", DefaultMinSpeed, "Minimum NIC speed in Mbps")
-	flag.IntVar(&cfg.MaxWorkers, "workers", DefaultMaxWorkers, "Maximum number of parallel workers")
+	flag.IntVar(&cfg.MaxWorkers, "workers", DefaultMaxWorkers, "Maximum number of parallel workers (at least 1)")
 	flag.BoolVar(&cfg.Verbose, "v", false, "Verbose output")
 	flag.StringVar(&cfg.LogFile, "log", DefaultLogFile, "Log file path")
+	flag.StringVar(&cfg.Backend, "backend", DefaultBackend, "Ethtool backend: auto, netlink or exec; driver statistics always run ethtool")
+	flag.StringVar(&cfg.RecordFile, "record", "", "Record ethtool invocations and outputs to a fixture file")
+	flag.StringVar(&cfg.ReplayFile, "replay", "", "Replay ethtool outputs from a fixture file instead of running ethtool")
+	flag.IntVar(&cfg.StatsInterval, "stats-interval", 0, "Seconds between counter samples in query mode to compute rates (0 = totals only)")
+	flag.IntVar(&cfg.CommandTimeout, "timeout", DefaultCommandTimeout, "Seconds before an ethtool command, netlink request or VPD read is abandoned (0 = no timeout)")
+	flag.IntVar(&cfg.NICTimeout, "nic-timeout", DefaultNICTimeout, "Seconds before a NIC whose discovery has not finished is reported UNRESPONSIVE (0 = no timeout)")
+	flag.IntVar(&cfg.SetRetries, "retries", DefaultSetRetries, "Retries of a ring change that fails because the device is busy (EBUSY)")
+	flag.IntVar(&cfg.RetryBackoff, "retry-backoff", DefaultRetryBackoff, "Milliseconds before the first EBUSY retry, doubled after every retry")
+	flag.IntVar(&cfg.MaxDisruptions, "max-disruptions", DefaultMaxDisruptions, "Maximum number of NICs changed at the same time (0 = no limit)")
+	flag.IntVar(&cfg.SettleDelay, "settle", DefaultSettleDelay, "Seconds to wait after a changed NIC's link is back before changing the next one")
+	flag.IntVar(&cfg.LinkTimeout, "link-timeout", DefaultLinkTimeout, "Seconds a changed NIC may take to come back before no further NIC is changed (0 = no limit)")
+	flag.StringVar(&cfg.StateFile, "state", DefaultStateFile, "File keeping the original settings of every changed NIC")
+	flag.StringVar(&cfg.Root, "root", DefaultRoot, "Root directory of the host's /sys (e.g. /host in a container, or a sysfs snapshot)")
+	flag.StringVar(&cfg.ConfigFile, "config", DefaultConfigFile, "Path to the JSON config file with desired NIC settings")
 
 	// Parse flags
 	flag.Parse()
 
 	// Determine mode
-	if *monitorMode {
+	if *restoreMode {
+		cfg.Mode = ModeRestore
+	} else if *inventoryMode {
+		cfg.Mode = ModeInventory
+	} else if *planMode {
+		cfg.Mode = ModePlan
+	} else if *monitorMode {
 		cfg.Mode = ModeMonitor
 	} else if *setMode {
 		cfg.Mode = ModeSet
@@ -71,5 +161,33 @@ func ParseFlags() *Config {
 		cfg.Mode = ModeQuery
 	}
 
+	// Discovery and optimization share the worker count; zero workers
+	// would never start any
+	if cfg.MaxWorkers < 1 {
+		fmt.Fprintf(os.Stderr, "-workers must be at least 1, got %d\n", cfg.MaxWorkers)
+		os.Exit(2)
+	}
+
+	// Settings replayed from a fixture or read under another root are not
+	// this host's, so they are only recorded in a state file given explicitly
+	if cfg.ReplayFile != "" || cfg.Root != DefaultRoot {
+		explicit := false
+		flag.Visit(func(f *flag.Flag) {
+			explicit = explicit || f.Name == "state"
+		})
+		if !explicit {
+			cfg.StateFile = ""
+		}
+	}
+
+	// Load the policy; a missing default config file is not an error
+	policy, err := LoadPolicy(cfg.ConfigFile)
+	if err == nil {
+		cfg.Policy = policy
+	} else if !(errors.Is(err, os.ErrNotExist) && cfg.ConfigFile == DefaultConfigFile) {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(2)
+	}
+
 	return cfg
-}
\ No newline at end of file
+}
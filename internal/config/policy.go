@@ -0,0 +1,290 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Channel policies
+const (
+	ChannelPolicyNone  = "none"  // leave channel counts alone
+	ChannelPolicyMax   = "max"   // raise channels to the pre-set maximum
+	ChannelPolicyNUMA  = "numa"  // match the number of NUMA-local online CPUs
+	ChannelPolicyCount = "count" // use a fixed count
+)
+
+// IPoIB modes
+const (
+	IPoIBModeDatagram  = "datagram"
+	IPoIBModeConnected = "connected"
+)
+
+// Function classes that can be selected for optimization
+const (
+	FunctionPF     = "pf"
+	FunctionVF     = "vf"
+	FunctionUplink = "uplink"
+	FunctionVFRep  = "vf-rep"
+)
+
+// DefaultFunctions are optimized when the config file does not list any:
+// VFs and VF representors are sized by whoever creates them
+var DefaultFunctions = []string{FunctionPF, FunctionUplink}
+
+// Policy holds the desired NIC settings loaded from the config file
+type Policy struct {
+	Ring     RingPolicy    `json:"ring"`
+	Channels ChannelPolicy `json:"channels"`
+
+	// Coalesce targets keyed by interface name or glob pattern
+	Coalesce map[string]CoalesceTarget `json:"coalesce"`
+
+	// Desired offload features (on = true) keyed by interface name or glob
+	// pattern. Feature names are ethtool -k names or -K aliases such as gro.
+	Offloads map[string]map[string]bool `json:"offloads"`
+
+	// Pause frame targets keyed by interface name or glob pattern
+	Pause map[string]PauseTarget `json:"pause"`
+
+	// Desired driver private flags keyed by driver name or glob pattern
+	PrivFlags map[string]map[string]bool `json:"priv_flags"`
+
+	// Required FEC encoding keyed by link speed in Mbps
+	FEC map[int]string `json:"fec"`
+
+	// IPoIB settings applied to every Infiniband interface
+	IPoIB IPoIBPolicy `json:"ipoib"`
+
+	// Function classes (pf, vf, uplink, vf-rep) that are optimized
+	Functions []string `json:"functions"`
+
+	// Include/exclude rules selecting the NICs that are handled
+	Select SelectPolicy `json:"select"`
+}
+
+// RingPolicy holds the RX/TX sizing rules and desired values for the other
+// ring parameters. RX/TX rings of NICs matching no rule are raised to the
+// pre-set maximum. Zero values and empty strings leave the parameter
+// untouched.
+type RingPolicy struct {
+	// Sizing rules, the first matching rule wins
+	Rules []RingRule `json:"rules,omitempty"`
+
+	RXMini       int    `json:"rx_mini,omitempty"`
+	RXJumbo      int    `json:"rx_jumbo,omitempty"`
+	RXBufLen     int    `json:"rx_buf_len,omitempty"`
+	CQESize      int    `json:"cqe_size,omitempty"`
+	TXPush       string `json:"tx_push,omitempty"`        // on, off
+	RXPush       string `json:"rx_push,omitempty"`        // on, off
+	TCPDataSplit string `json:"tcp_data_split,omitempty"` // on, off, auto
+}
+
+// ChannelPolicy selects the combined channel count applied to every NIC
+type ChannelPolicy struct {
+	Policy string `json:"policy,omitempty"` // none, max, numa, count
+	Count  int    `json:"count,omitempty"`  // used by the count policy
+}
+
+// IPoIBPolicy holds the desired IPoIB settings. Send and receive rings are
+// sized by the ring rules like Ethernet rings.
+type IPoIBPolicy struct {
+	Mode string `json:"mode,omitempty"` // datagram, connected
+}
+
+// PauseTarget is the desired flow-control setting of an interface. Nil
+// fields are left untouched.
+type PauseTarget struct {
+	Autoneg *bool `json:"autoneg,omitempty"`
+	RX      *bool `json:"rx,omitempty"`
+	TX      *bool `json:"tx,omitempty"`
+}
+
+// CoalesceTarget is the desired interrupt coalescing of an interface,
+// written in the config file as ethtool -C names and values:
+//
+//	{"adaptive-rx": "off", "rx-usecs": 8, "rx-frames": 32}
+type CoalesceTarget struct {
+	AdaptiveRX string
+	AdaptiveTX string
+	Params     map[string]int
+}
+
+// UnmarshalJSON decodes the flat ethtool -C style object
+func (t *CoalesceTarget) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	t.Params = make(map[string]int)
+	for key, value := range raw {
+		var err error
+		switch key {
+		case "adaptive-rx":
+			err = json.Unmarshal(value, &t.AdaptiveRX)
+		case "adaptive-tx":
+			err = json.Unmarshal(value, &t.AdaptiveTX)
+		default:
+			var v int
+			err = json.Unmarshal(value, &v)
+			t.Params[key] = v
+		}
+		if err != nil {
+			return fmt.Errorf("coalesce parameter %s: %v", key, err)
+		}
+	}
+
+	return nil
+}
+
+// CoalesceFor returns the coalesce target for an interface
+func (p *Policy) CoalesceFor(name string) (CoalesceTarget, bool) {
+	return forName(p.Coalesce, name)
+}
+
+// OffloadsFor returns the desired offload features for an interface
+func (p *Policy) OffloadsFor(name string) (map[string]bool, bool) {
+	return forName(p.Offloads, name)
+}
+
+// PauseFor returns the pause frame target for an interface
+func (p *Policy) PauseFor(name string) (PauseTarget, bool) {
+	return forName(p.Pause, name)
+}
+
+// PrivFlagsFor returns the desired private flags for a driver
+func (p *Policy) PrivFlagsFor(driver string) (map[string]bool, bool) {
+	return forName(p.PrivFlags, driver)
+}
+
+// forName looks up a setting keyed by name or glob pattern. An exact match
+// wins, otherwise the longest matching pattern (so "*" is the default).
+func forName[T any](settings map[string]T, name string) (T, bool) {
+	if v, ok := settings[name]; ok {
+		return v, true
+	}
+
+	var best T
+	bestLen := -1
+	for pattern, v := range settings {
+		if ok, _ := filepath.Match(pattern, name); ok && len(pattern) > bestLen {
+			best, bestLen = v, len(pattern)
+		}
+	}
+	return best, bestLen >= 0
+}
+
+// LoadPolicy reads the policy from a JSON config file
+func LoadPolicy(path string) (Policy, error) {
+	var policy Policy
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policy, err
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return policy, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return policy, nil
+}
+
+// validate checks enumerated values in the policy
+func (p *Policy) validate() error {
+	for name, value := range map[string]string{
+		"ring.tx_push": p.Ring.TXPush,
+		"ring.rx_push": p.Ring.RXPush,
+	} {
+		if value != "" && value != "on" && value != "off" {
+			return fmt.Errorf("%s must be on or off, got %q", name, value)
+		}
+	}
+	if err := p.Ring.validateRules(); err != nil {
+		return err
+	}
+	switch p.Ring.TCPDataSplit {
+	case "", "on", "off", "auto":
+	default:
+		return fmt.Errorf("ring.tcp_data_split must be on, off or auto, got %q", p.Ring.TCPDataSplit)
+	}
+	for _, err := range []error{
+		checkPatterns("coalesce", p.Coalesce),
+		checkPatterns("offloads", p.Offloads),
+		checkPatterns("pause", p.Pause),
+		checkPatterns("priv_flags", p.PrivFlags),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	for pattern, target := range p.Coalesce {
+		for name, value := range map[string]string{"adaptive-rx": target.AdaptiveRX, "adaptive-tx": target.AdaptiveTX} {
+			if value != "" && value != "on" && value != "off" {
+				return fmt.Errorf("coalesce.%s: %s must be on or off, got %q", pattern, name, value)
+			}
+		}
+	}
+	for speed, encoding := range p.FEC {
+		switch strings.ToLower(encoding) {
+		case "auto", "off", "rs", "baser", "llrs":
+		default:
+			return fmt.Errorf("fec.%d: unknown encoding %q", speed, encoding)
+		}
+	}
+	switch p.IPoIB.Mode {
+	case "", IPoIBModeDatagram, IPoIBModeConnected:
+	default:
+		return fmt.Errorf("ipoib.mode must be datagram or connected, got %q", p.IPoIB.Mode)
+	}
+	if err := p.Select.validate(); err != nil {
+		return err
+	}
+	for _, f := range p.Functions {
+		switch f {
+		case FunctionPF, FunctionVF, FunctionUplink, FunctionVFRep:
+		default:
+			return fmt.Errorf("unknown function class %q in functions", f)
+		}
+	}
+	switch p.Channels.Policy {
+	case "", ChannelPolicyNone, ChannelPolicyMax, ChannelPolicyNUMA:
+	case ChannelPolicyCount:
+		if p.Channels.Count <= 0 {
+			return fmt.Errorf("channels.count must be positive for the count policy")
+		}
+	default:
+		return fmt.Errorf("unknown channels.policy %q", p.Channels.Policy)
+	}
+
+	return nil
+}
+
+// OptimizesFunction reports whether NICs of a function class are optimized
+func (p *Policy) OptimizesFunction(class string) bool {
+	functions := p.Functions
+	if len(functions) == 0 {
+		functions = DefaultFunctions
+	}
+	for _, f := range functions {
+		if f == class {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPatterns validates the glob patterns used as keys of a section
+func checkPatterns[T any](section string, settings map[string]T) error {
+	for pattern := range settings {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: invalid pattern %q", section, pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RingRule sizes the RX/TX rings of the NICs it matches. Every match field
+// that is set must match; values are globs or /regexp/ like select rules,
+// and PCI IDs and link types are compared in lowercase the same way.
+type RingRule struct {
+	Name     string `json:"name,omitempty"`      // interface name
+	Driver   string `json:"driver,omitempty"`    // kernel driver
+	PCIID    string `json:"pci_id,omitempty"`    // vendor:device, e.g. 15b3:1021
+	LinkType string `json:"link_type,omitempty"` // Ethernet, Infiniband
+	MinSpeed int    `json:"min_speed,omitempty"` // Mbps, inclusive
+	MaxSpeed int    `json:"max_speed,omitempty"` // Mbps, inclusive
+
+	RX RingSize `json:"rx"`
+	TX RingSize `json:"tx"`
+
+	// Patterns compiled when the policy is loaded
+	matchers *ringMatchers
+}
+
+// ringMatchers holds the compiled patterns of a ring rule
+type ringMatchers struct {
+	name, driver, pciID, linkType fieldMatcher
+}
+
+// RingSize is a ring size target, written in the config file as a number of
+// entries (4096), a percentage of the pre-set maximum ("50%") or "max". The
+// zero value leaves the ring untouched.
+type RingSize struct {
+	Entries int
+	Percent int
+	Max     bool
+}
+
+// RingSizeMax raises a ring to the pre-set maximum
+var RingSizeMax = RingSize{Max: true}
+
+// UnmarshalJSON decodes a number, "N%" or "max"
+func (s *RingSize) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		if n <= 0 {
+			return fmt.Errorf("ring size must be positive, got %d", n)
+		}
+		*s = RingSize{Entries: n}
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("ring size must be a number, a percentage or \"max\"")
+	}
+	return s.parse(str)
+}
+
+// parse decodes the string form of a ring size
+func (s *RingSize) parse(str string) error {
+	switch {
+	case str == "max":
+		*s = RingSizeMax
+	case strings.HasSuffix(str, "%"):
+		pct, err := strconv.Atoi(strings.TrimSuffix(str, "%"))
+		if err != nil || pct <= 0 || pct > 100 {
+			return fmt.Errorf("ring size percentage must be between 1%% and 100%%, got %q", str)
+		}
+		*s = RingSize{Percent: pct}
+	default:
+		n, err := strconv.Atoi(str)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("ring size must be a number, a percentage or \"max\", got %q", str)
+		}
+		*s = RingSize{Entries: n}
+	}
+	return nil
+}
+
+// IsZero reports whether the size leaves the ring untouched
+func (s RingSize) IsZero() bool {
+	return s == RingSize{}
+}
+
+// Resolve returns the number of entries for a ring whose pre-set maximum is
+// max, clamped to [1, max]. It returns cur for the zero size, and cur with
+// an error for "max" or a percentage when the driver reports no maximum.
+func (s RingSize) Resolve(cur, max int) (int, error) {
+	var n int
+	switch {
+	case (s.Max || s.Percent > 0) && max <= 0:
+		return cur, fmt.Errorf("%s needs the pre-set maximum, which is not reported", s)
+	case s.Max:
+		n = max
+	case s.Percent > 0:
+		n = max * s.Percent / 100
+	case s.Entries > 0:
+		n = s.Entries
+	default:
+		return cur, nil
+	}
+	if max > 0 && n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n, nil
+}
+
+// String formats the size as written in the config file
+func (s RingSize) String() string {
+	switch {
+	case s.Max:
+		return "max"
+	case s.Percent > 0:
+		return fmt.Sprintf("%d%%", s.Percent)
+	case s.Entries > 0:
+		return strconv.Itoa(s.Entries)
+	default:
+		return "unchanged"
+	}
+}
+
+// Matches reports whether every match field set in the rule matches the NIC
+func (r RingRule) Matches(t SelectTarget) bool {
+	if r.MinSpeed > 0 && t.Speed < r.MinSpeed {
+		return false
+	}
+	if r.MaxSpeed > 0 && t.Speed > r.MaxSpeed {
+		return false
+	}
+
+	m := r.matchers
+	if m == nil {
+		// A rule that did not come from LoadPolicy; invalid patterns match
+		// nothing
+		m, _ = r.compile()
+	}
+	return m.name.match(t.Name, false) &&
+		m.driver.match(t.Driver, false) &&
+		m.pciID.match(t.PCIID, false) &&
+		m.linkType.match(strings.ToLower(t.LinkType), false)
+}
+
+// compile compiles the patterns of the rule. PCI IDs and link types are
+// compared in lowercase.
+func (r RingRule) compile() (*ringMatchers, error) {
+	var m ringMatchers
+	var errs []error
+	for _, f := range []struct {
+		dst     *fieldMatcher
+		pattern string
+		lower   bool
+	}{
+		{&m.name, r.Name, false},
+		{&m.driver, r.Driver, false},
+		{&m.pciID, r.PCIID, true},
+		{&m.linkType, r.LinkType, true},
+	} {
+		var err error
+		if *f.dst, err = compileField(f.pattern, f.lower); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return &m, errors.Join(errs...)
+}
+
+// String lists the match fields set in the rule
+func (r RingRule) String() string {
+	var fields []string
+	for _, f := range []struct{ name, value string }{
+		{"name", r.Name},
+		{"driver", r.Driver},
+		{"pci_id", r.PCIID},
+		{"link_type", r.LinkType},
+	} {
+		if f.value != "" {
+			fields = append(fields, f.name+"="+f.value)
+		}
+	}
+	if r.MinSpeed > 0 {
+		fields = append(fields, fmt.Sprintf("min_speed=%d", r.MinSpeed))
+	}
+	if r.MaxSpeed > 0 {
+		fields = append(fields, fmt.Sprintf("max_speed=%d", r.MaxSpeed))
+	}
+	if len(fields) == 0 {
+		return "any"
+	}
+	return strings.Join(fields, " ")
+}
+
+// RingSizeFor returns the RX/TX sizes of the first ring rule matching the
+// NIC and a description of the rule. NICs matching no rule get the pre-set
+// maximum, with an empty description.
+func (r *RingPolicy) RingSizeFor(t SelectTarget) (rx, tx RingSize, rule string) {
+	for i, rr := range r.Rules {
+		if rr.Matches(t) {
+			return rr.RX, rr.TX, fmt.Sprintf("ring.rules[%d] (%s)", i, rr)
+		}
+	}
+	return RingSizeMax, RingSizeMax, ""
+}
+
+// validateRules checks the sizes of the ring rules and compiles their match
+// patterns
+func (r *RingPolicy) validateRules() error {
+	for i, rule := range r.Rules {
+		if rule.RX.IsZero() && rule.TX.IsZero() {
+			return fmt.Errorf("ring.rules[%d] sets neither rx nor tx", i)
+		}
+		if rule.MaxSpeed > 0 && rule.MaxSpeed < rule.MinSpeed {
+			return fmt.Errorf("ring.rules[%d]: max_speed is below min_speed", i)
+		}
+		m, err := rule.compile()
+		if err != nil {
+			return fmt.Errorf("ring.rules[%d]: %v", i, err)
+		}
+		r.Rules[i].matchers = m
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRingSizeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    RingSize
+		wantErr bool
+	}{
+		{input: `4096`, want: RingSize{Entries: 4096}},
+		{input: `"4096"`, want: RingSize{Entries: 4096}},
+		{input: `"50%"`, want: RingSize{Percent: 50}},
+		{input: `"100%"`, want: RingSize{Percent: 100}},
+		{input: `"max"`, want: RingSizeMax},
+		{input: `0`, wantErr: true},
+		{input: `-1`, wantErr: true},
+		{input: `"0%"`, wantErr: true},
+		{input: `"101%"`, wantErr: true},
+		{input: `"abc"`, wantErr: true},
+		{input: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got RingSize
+			err := json.Unmarshal([]byte(tt.input), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Unmarshal = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRingSizeResolve(t *testing.T) {
+	tests := []struct {
+		size     RingSize
+		cur, max int
+		want     int
+		wantErr  bool
+	}{
+		{RingSize{}, 1024, 8192, 1024, false},
+		{RingSizeMax, 1024, 8192, 8192, false},
+		{RingSize{Percent: 50}, 1024, 8192, 4096, false},
+		{RingSize{Percent: 1}, 1024, 50, 1, false}, // rounds down to 0, clamped to 1
+		{RingSize{Entries: 4096}, 1024, 8192, 4096, false},
+		{RingSize{Entries: 16384}, 1024, 8192, 8192, false},
+		{RingSize{Entries: 16384}, 1024, 0, 16384, false}, // unknown maximum
+		// Relative sizes of an unknown maximum leave the ring unchanged
+		{RingSize{Percent: 50}, 1024, 0, 1024, true},
+		{RingSizeMax, 1024, 0, 1024, true},
+		{RingSize{}, 1024, 0, 1024, false},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.size.Resolve(tt.cur, tt.max)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s.Resolve(%d, %d) = %d, %v, want %d, error %v", tt.size, tt.cur, tt.max, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRingSizeFor(t *testing.T) {
+	policy := RingPolicy{Rules: []RingRule{
+		{PCIID: "15B3:*", MinSpeed: 200000, RX: RingSizeMax, TX: RingSize{Percent: 50}},
+		{LinkType: "InfiniBand", RX: RingSize{Entries: 4096}},
+		{Name: "/^eth[0-9]+$/", MaxSpeed: 25000, TX: RingSize{Entries: 1024}},
+	}}
+
+	tests := []struct {
+		name     string
+		target   SelectTarget
+		rx, tx   RingSize
+		wantRule string
+	}{
+		{
+			name:     "uppercase glob PCI ID and speed",
+			target:   SelectTarget{Name: "eth0", PCIID: "15b3:1021", Speed: 400000},
+			rx:       RingSizeMax,
+			tx:       RingSize{Percent: 50},
+			wantRule: "ring.rules[0] (pci_id=15B3:* min_speed=200000)",
+		},
+		{
+			name:     "link type in any case",
+			target:   SelectTarget{Name: "ib0", PCIID: "15b3:1021", LinkType: "Infiniband", Speed: 100000},
+			rx:       RingSize{Entries: 4096},
+			wantRule: "ring.rules[1] (link_type=InfiniBand)",
+		},
+		{
+			name:     "regexp name and max speed",
+			target:   SelectTarget{Name: "eth1", PCIID: "8086:159b", LinkType: "Ethernet", Speed: 25000},
+			tx:       RingSize{Entries: 1024},
+			wantRule: "ring.rules[2] (name=/^eth[0-9]+$/ max_speed=25000)",
+		},
+		{
+			name:   "no rule",
+			target: SelectTarget{Name: "eth1", PCIID: "8086:159b", LinkType: "Ethernet", Speed: 100000},
+			rx:     RingSizeMax,
+			tx:     RingSizeMax,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rx, tx, rule := policy.RingSizeFor(tt.target)
+			if rx != tt.rx || tx != tt.tx || rule != tt.wantRule {
+				t.Errorf("RingSizeFor = %s, %s, %q, want %s, %s, %q", rx, tx, rule, tt.rx, tt.tx, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestRingPolicyValidateRules(t *testing.T) {
+	policy := RingPolicy{Rules: []RingRule{{LinkType: "InfiniBand", RX: RingSizeMax}}}
+	if err := policy.validateRules(); err != nil {
+		t.Fatalf("validateRules: %v", err)
+	}
+	if policy.Rules[0].matchers == nil {
+		t.Fatal("validateRules did not compile the rule")
+	}
+	if !policy.Rules[0].Matches(SelectTarget{LinkType: "Infiniband"}) {
+		t.Error("compiled rule does not match")
+	}
+
+	for _, bad := range []RingRule{
+		{Name: "eth*"},
+		{MinSpeed: 200000, MaxSpeed: 100000, RX: RingSizeMax},
+		{Driver: "mlx5[", RX: RingSizeMax},
+		{PCIID: "/(/", TX: RingSizeMax},
+	} {
+		policy := RingPolicy{Rules: []RingRule{bad}}
+		if err := policy.validateRules(); err == nil {
+			t.Errorf("validateRules(%s) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SelectPolicy chooses the NICs handled beyond the -min-speed cutoff.
+// A NIC matching an include rule is selected whatever its speed; a
+// selected NIC matching an exclude rule is shown but left alone.
+type SelectPolicy struct {
+	Include []SelectRule `json:"include,omitempty"`
+	Exclude []SelectRule `json:"exclude,omitempty"`
+}
+
+// SelectRule matches NICs on every field that is set. Values are glob
+// patterns, or regular expressions when written as /regexp/. A plain MAC
+// value also matches as a prefix. PCI IDs, slots and MACs are compared in
+// lowercase: globs are lowercased, regexps are used as written and can be
+// made case-insensitive with (?i).
+type SelectRule struct {
+	Name    string `json:"name,omitempty"`     // interface name
+	Driver  string `json:"driver,omitempty"`   // kernel driver
+	PCIID   string `json:"pci_id,omitempty"`   // vendor:device, e.g. 15b3:1021
+	PCISlot string `json:"pci_slot,omitempty"` // PCI address, e.g. 0000:3b:00.*
+	MAC     string `json:"mac,omitempty"`      // MAC address prefix
+
+	// Patterns compiled when the policy is loaded
+	matchers *selectMatchers
+}
+
+// selectMatchers holds the compiled patterns of a select rule
+type selectMatchers struct {
+	name, driver, pciID, pciSlot, mac fieldMatcher
+}
+
+// SelectTarget holds the attributes of a NIC that select and ring rules
+// match against
+type SelectTarget struct {
+	Name     string
+	Driver   string
+	PCIID    string
+	PCISlot  string
+	MAC      string
+	LinkType string
+	Speed    int // Mbps
+}
+
+// Active reports whether any rule is configured
+func (s *SelectPolicy) Active() bool {
+	return len(s.Include) > 0 || len(s.Exclude) > 0
+}
+
+// Included returns the first include rule matching the NIC
+func (s *SelectPolicy) Included(t SelectTarget) (string, bool) {
+	return firstMatch("select.include", s.Include, t)
+}
+
+// Excluded returns the first exclude rule matching the NIC
+func (s *SelectPolicy) Excluded(t SelectTarget) (string, bool) {
+	return firstMatch("select.exclude", s.Exclude, t)
+}
+
+// firstMatch returns a description of the first matching rule
+func firstMatch(section string, rules []SelectRule, t SelectTarget) (string, bool) {
+	for i, rule := range rules {
+		if rule.Matches(t) {
+			return fmt.Sprintf("%s[%d] (%s)", section, i, rule), true
+		}
+	}
+	return "", false
+}
+
+// Matches reports whether every field set in the rule matches the NIC
+func (r SelectRule) Matches(t SelectTarget) bool {
+	m := r.matchers
+	if m == nil {
+		// A rule that did not come from LoadPolicy; invalid patterns match
+		// nothing
+		m, _ = r.compile()
+	}
+	return m.name.match(t.Name, false) &&
+		m.driver.match(t.Driver, false) &&
+		m.pciID.match(t.PCIID, false) &&
+		m.pciSlot.match(t.PCISlot, false) &&
+		m.mac.match(strings.ToLower(t.MAC), true)
+}
+
+// compile compiles the patterns of the rule. PCI IDs, slots and MACs are
+// compared in lowercase.
+func (r SelectRule) compile() (*selectMatchers, error) {
+	var m selectMatchers
+	var errs []error
+	for _, f := range []struct {
+		dst     *fieldMatcher
+		pattern string
+		lower   bool
+	}{
+		{&m.name, r.Name, false},
+		{&m.driver, r.Driver, false},
+		{&m.pciID, r.PCIID, true},
+		{&m.pciSlot, r.PCISlot, true},
+		{&m.mac, r.MAC, true},
+	} {
+		var err error
+		if *f.dst, err = compileField(f.pattern, f.lower); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return &m, errors.Join(errs...)
+}
+
+// String lists the fields set in the rule
+func (r SelectRule) String() string {
+	var fields []string
+	for _, f := range []struct{ name, value string }{
+		{"name", r.Name},
+		{"driver", r.Driver},
+		{"pci_id", r.PCIID},
+		{"pci_slot", r.PCISlot},
+		{"mac", r.MAC},
+	} {
+		if f.value != "" {
+			fields = append(fields, f.name+"="+f.value)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// fieldMatcher is a compiled glob or /regexp/ match pattern. The zero
+// value matches anything.
+type fieldMatcher struct {
+	glob string
+	re   *regexp.Regexp
+	bad  bool // invalid pattern, matches nothing
+}
+
+// compileField compiles a glob or /regexp/ pattern. With lower, a glob is
+// lowercased for a field compared in lowercase; a regexp is used as written,
+// since lowercasing changes what escapes and character classes such as \D
+// or [A-F] match, and can be made case-insensitive with (?i).
+func compileField(pattern string, lower bool) (fieldMatcher, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return fieldMatcher{bad: true}, fmt.Errorf("invalid regular expression %s", pattern)
+		}
+		return fieldMatcher{re: re}, nil
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fieldMatcher{bad: true}, fmt.Errorf("invalid pattern %q", pattern)
+	}
+	if lower {
+		pattern = strings.ToLower(pattern)
+	}
+	return fieldMatcher{glob: pattern}, nil
+}
+
+// match matches a value against the pattern; prefix also accepts a glob
+// that is a plain prefix of the value
+func (f fieldMatcher) match(value string, prefix bool) bool {
+	switch {
+	case f.bad:
+		return false
+	case f.re != nil:
+		return f.re.MatchString(value)
+	case f.glob == "":
+		return true
+	case prefix && strings.HasPrefix(value, f.glob):
+		return true
+	}
+	ok, _ := filepath.Match(f.glob, value)
+	return ok
+}
+
+// validate checks and compiles every pattern of the select rules
+func (s *SelectPolicy) validate() error {
+	for section, rules := range map[string][]SelectRule{
+		"select.include": s.Include,
+		"select.exclude": s.Exclude,
+	} {
+		for i, rule := range rules {
+			if rule == (SelectRule{}) {
+				return fmt.Errorf("%s[%d] has no fields", section, i)
+			}
+			m, err := rule.compile()
+			if err != nil {
+				return fmt.Errorf("%s[%d]: %v", section, i, err)
+			}
+			rules[i].matchers = m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestSelectRuleMatches(t *testing.T) {
+	target := SelectTarget{
+		Name:    "eth0",
+		Driver:  "mlx5_core",
+		PCIID:   "15b3:1021",
+		PCISlot: "0000:3b:00.0",
+		MAC:     "02:fc:aa:bb:cc:dd",
+	}
+
+	tests := []struct {
+		name string
+		rule SelectRule
+		want bool
+	}{
+		{"empty rule", SelectRule{}, true},
+		{"name glob", SelectRule{Name: "eth*"}, true},
+		{"name mismatch", SelectRule{Name: "ib*"}, false},
+		{"uppercase glob PCI ID", SelectRule{PCIID: "15B3:*"}, true},
+		{"uppercase PCI slot", SelectRule{PCISlot: "0000:3B:00.*"}, true},
+		{"regexp is not lowercased", SelectRule{PCIID: `/^\D/`}, false},
+		{"regexp class", SelectRule{PCIID: `/\D/`}, true},
+		{"case-sensitive regexp", SelectRule{MAC: "/^02:FC/"}, false},
+		{"case-insensitive regexp", SelectRule{MAC: "/(?i)^02:FC/"}, true},
+		{"uppercase MAC prefix", SelectRule{MAC: "02:FC:AA"}, true},
+		{"MAC prefix mismatch", SelectRule{MAC: "02:fd"}, false},
+		{"every field must match", SelectRule{Name: "eth0", Driver: "ice"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Matches(target); got != tt.want {
+				t.Errorf("%s matches = %v, want %v", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileField(t *testing.T) {
+	for pattern, wantErr := range map[string]bool{
+		"":           false,
+		"eth*":       false,
+		"0000:3b:*":  false,
+		"/^eth\\d$/": false,
+		"eth[":       true,
+		"/eth(/":     true,
+		"/":          false, // a lone slash is a glob
+	} {
+		if _, err := compileField(pattern, false); (err != nil) != wantErr {
+			t.Errorf("compileField(%q) = %v, wantErr %v", pattern, err, wantErr)
+		}
+	}
+}
+
+func TestSelectPolicyValidate(t *testing.T) {
+	policy := SelectPolicy{
+		Include: []SelectRule{{PCIID: "15B3:*"}},
+		Exclude: []SelectRule{{MAC: "/(?i)^02:FC/"}},
+	}
+	if err := policy.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if policy.Include[0].matchers == nil || policy.Exclude[0].matchers == nil {
+		t.Fatal("validate did not compile the rules")
+	}
+
+	target := SelectTarget{PCIID: "15b3:1021", MAC: "02:fc:aa:bb:cc:dd"}
+	if rule, ok := policy.Included(target); !ok || rule != "select.include[0] (pci_id=15B3:*)" {
+		t.Errorf("Included = %q, %v", rule, ok)
+	}
+	if _, ok := policy.Excluded(target); !ok {
+		t.Error("Excluded did not match")
+	}
+
+	for _, bad := range []SelectPolicy{
+		{Include: []SelectRule{{}}},
+		{Exclude: []SelectRule{{Name: "eth["}}},
+		{Exclude: []SelectRule{{MAC: "/(/"}}},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("validate(%+v) succeeded", bad)
+		}
+	}
+}
@@ -1,19 +1,23 @@
 package monitor
 
 import (
+	"context"
 	"time"
 
 	"optimize-hpc-nic/internal/config"
 	"optimize-hpc-nic/internal/logger"
 	"optimize-hpc-nic/internal/nic"
 	"optimize-hpc-nic/internal/ringbuffer"
+	"optimize-hpc-nic/internal/stats"
 )
 
 // Service is the monitoring service
 type Service struct {
 	cfg       *config.Config
 	log       *logger.Logger
+	nicMgr    *nic.Manager
 	optimizer *ringbuffer.Optimizer
+	stats     *stats.Collector // keeps the previous sample of every NIC
 	stopChan  chan struct{}
 }
 
@@ -22,17 +26,20 @@ func New(nicMgr *nic.Manager, cfg *config.Config, log *logger.Logger) *Service {
 	return &Service{
 		cfg:       cfg,
 		log:       log,
+		nicMgr:    nicMgr,
 		optimizer: ringbuffer.New(nicMgr, log, cfg), // 正确的参数顺序：nicMgr, log, cfg
+		stats:     stats.NewCollector(nicMgr.Ethtool(), nicMgr.FS()),
 		stopChan:  make(chan struct{}),
 	}
 }
 
-// Start starts the monitoring service
-func (s *Service) Start() {
+// Start starts the monitoring service. It returns when ctx is cancelled or
+// Stop is called; cancelling ctx also abandons a discovery in progress.
+func (s *Service) Start(ctx context.Context) {
 	s.log.Info("Starting monitoring with interval: %d seconds", s.cfg.MonitorInterval)
 
 	// Initial configuration
-	s.optimizer.OptimizeAll(false)
+	s.checkAndOptimize(ctx)
 
 	// Monitor loop
 	ticker := time.NewTicker(time.Duration(s.cfg.MonitorInterval) * time.Second)
@@ -42,10 +49,13 @@ func (s *Service) Start() {
 		select {
 		case <-ticker.C:
 			s.log.Info("Performing scheduled ring buffer check")
-			s.checkAndOptimize()
+			s.checkAndOptimize(ctx)
 		case <-s.stopChan:
 			s.log.Info("Monitoring service stopped")
 			return
+		case <-ctx.Done():
+			s.log.Info("Monitoring service stopped")
+			return
 		}
 	}
 }
@@ -55,8 +65,34 @@ func (s *Service) Stop() {
 	close(s.stopChan)
 }
 
-// checkAndOptimize checks and optimizes ring buffer settings
-func (s *Service) checkAndOptimize() {
+// checkAndOptimize checks and optimizes ring buffer settings, then samples
+// the counters of the NICs it discovered
+func (s *Service) checkAndOptimize(ctx context.Context) {
 	// Optimize all NICs
-	s.optimizer.OptimizeAll(false)
+	nics, err := s.optimizer.OptimizeAll(ctx, false)
+	if err != nil {
+		return
+	}
+	s.collectStats(ctx, nics)
+}
+
+// collectStats samples the counters of every high-speed NIC and logs the
+// drop and error counters that increased since the previous tick
+func (s *Service) collectStats(ctx context.Context, nics []*nic.NIC) {
+	for _, n := range nics {
+		if n.Unresponsive {
+			continue
+		}
+		deltas, err := s.stats.Collect(ctx, n.Name)
+		if err != nil {
+			s.log.Debug("%v", err)
+			continue
+		}
+		for _, d := range stats.Watched(deltas) {
+			if d.Delta > 0 {
+				s.log.Info("%s: %s %s increased by %d (%.1f/s, total %d)",
+					n.Name, d.Source, d.Name, d.Delta, d.Rate, d.Value)
+			}
+		}
+	}
 }
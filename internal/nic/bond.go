@@ -0,0 +1,78 @@
+package nic
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Aggregate interface types
+const (
+	MasterTypeBond = "bond"
+	MasterTypeTeam = "team"
+)
+
+// Membership describes the bond or team an interface is enslaved to
+type Membership struct {
+	Master    string
+	Type      string // bond or team
+	Mode      string // bond mode, e.g. 802.3ad; empty for teams
+	MIIStatus string // link status as seen by the bond: up or down
+	State     string // active or backup
+}
+
+// GetMembership returns the bond or team an interface belongs to, or nil if
+// it is not enslaved to one
+func (m *Manager) GetMembership(ctx context.Context, name string) (*Membership, error) {
+	link, err := m.fs.Readlink(fmt.Sprintf("/sys/class/net/%s/master", name))
+	if err != nil {
+		// No master
+		return nil, nil
+	}
+	master := path.Base(link)
+	masterDir := fmt.Sprintf("/sys/class/net/%s", master)
+
+	if m.fs.Exists(path.Join(masterDir, "bonding")) {
+		// "802.3ad 4" -> "802.3ad"
+		mode, _ := m.fs.ReadString(path.Join(masterDir, "bonding/mode"))
+		if fields := strings.Fields(mode); len(fields) > 0 {
+			mode = fields[0]
+		}
+		slaveDir := fmt.Sprintf("/sys/class/net/%s/bonding_slave", name)
+		mii, _ := m.fs.ReadString(path.Join(slaveDir, "mii_status"))
+		state, _ := m.fs.ReadString(path.Join(slaveDir, "state"))
+		return &Membership{
+			Master:    master,
+			Type:      MasterTypeBond,
+			Mode:      mode,
+			MIIStatus: mii,
+			State:     state,
+		}, nil
+	}
+
+	// Teams expose nothing in sysfs; teamd's ports are identified by the
+	// master's driver and report their own link state
+	driver, err := m.ethtool.GetDriverInfo(ctx, master)
+	if err != nil || driver.Driver != MasterTypeTeam {
+		// Bridges, OVS and other masters are not aggregates
+		return nil, nil
+	}
+	operstate, _ := m.fs.ReadString(fmt.Sprintf("/sys/class/net/%s/operstate", name))
+	return &Membership{
+		Master:    master,
+		Type:      MasterTypeTeam,
+		MIIStatus: operstate,
+	}, nil
+}
+
+// IsSlaveUp reports whether a bond or team member is back in service: its
+// MII status for bond slaves, its operstate for team ports
+func (m *Manager) IsSlaveUp(name string) bool {
+	mii, err := m.fs.ReadString(fmt.Sprintf("/sys/class/net/%s/bonding_slave/mii_status", name))
+	if err == nil {
+		return mii == "up"
+	}
+	operstate, err := m.fs.ReadString(fmt.Sprintf("/sys/class/net/%s/operstate", name))
+	return err == nil && operstate == "up"
+}
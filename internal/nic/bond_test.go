@@ -0,0 +1,36 @@
+package nic
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGetMembership(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+
+	bond, err := m.GetMembership(ctx, "eth0")
+	if err != nil || bond == nil {
+		t.Fatalf("GetMembership(eth0) = %v, %v", bond, err)
+	}
+	want := Membership{Master: "bond0", Type: MasterTypeBond, Mode: "802.3ad", MIIStatus: "down", State: "backup"}
+	if *bond != want {
+		t.Errorf("GetMembership(eth0) = %+v, want %+v", *bond, want)
+	}
+
+	if bond, err := m.GetMembership(ctx, "eth1"); bond != nil || err != nil {
+		t.Errorf("GetMembership(eth1) = %+v, %v, want no master", bond, err)
+	}
+}
+
+func TestIsSlaveUp(t *testing.T) {
+	m := newTestManager(t)
+
+	// eth0 is a bond slave whose MII status is down; eth1 is in no bond and
+	// falls back to its operstate
+	for name, want := range map[string]bool{"eth0": false, "eth1": true, "eth9": false} {
+		if got := m.IsSlaveUp(name); got != want {
+			t.Errorf("IsSlaveUp(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package nic
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RDMAPort describes a port of an RDMA device under /sys/class/infiniband
+type RDMAPort struct {
+	Device          string // e.g. mlx5_0
+	Port            int
+	NetDev          string // e.g. ib0 or eth2, empty if the port has no netdev
+	State           string // e.g. ACTIVE
+	PhysState       string // e.g. LinkUp
+	Rate            string // e.g. "200 Gb/sec (4X HDR)"
+	LID             string
+	PortGUID        string
+	NodeGUID        string
+	FirmwareVersion string
+	LinkLayer       string // InfiniBand or Ethernet (RoCE)
+}
+
+// Name returns the device/port pair, e.g. mlx5_0/1
+func (p RDMAPort) Name() string {
+	return fmt.Sprintf("%s/%d", p.Device, p.Port)
+}
+
+// RateMbps returns the port rate in Mbps, or 0 if it is unknown
+func (p RDMAPort) RateMbps() int {
+	fields := strings.Fields(p.Rate)
+	if len(fields) < 2 || fields[1] != "Gb/sec" {
+		return 0
+	}
+	gbps, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return int(gbps * 1000)
+}
+
+// GetRDMAPorts enumerates the ports of every RDMA device and maps each to
+// its netdev
+func (m *Manager) GetRDMAPorts() ([]RDMAPort, error) {
+	devices, err := m.fs.ReadDir("/sys/class/infiniband")
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []RDMAPort
+	for _, device := range devices {
+		dir := path.Join("/sys/class/infiniband", device.Name())
+		fw, _ := m.fs.ReadString(path.Join(dir, "fw_ver"))
+		nodeGUID, _ := m.fs.ReadString(path.Join(dir, "node_guid"))
+
+		entries, err := m.fs.ReadDir(path.Join(dir, "ports"))
+		if err != nil {
+			m.log.Debug("Failed to list ports of %s: %v", device.Name(), err)
+			continue
+		}
+		for _, entry := range entries {
+			num, err := strconv.Atoi(entry.Name())
+			if err != nil {
+				continue
+			}
+			portDir := path.Join(dir, "ports", entry.Name())
+			read := func(attr string) string {
+				v, _ := m.fs.ReadString(path.Join(portDir, attr))
+				return v
+			}
+
+			ports = append(ports, RDMAPort{
+				Device:          device.Name(),
+				Port:            num,
+				NetDev:          m.rdmaNetDev(dir, num),
+				State:           stripStateCode(read("state")),
+				PhysState:       stripStateCode(read("phys_state")),
+				Rate:            read("rate"),
+				LID:             read("lid"),
+				PortGUID:        gidInterfaceID(read("gids/0")),
+				NodeGUID:        nodeGUID,
+				FirmwareVersion: fw,
+				LinkLayer:       read("link_layer"),
+			})
+		}
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Device != ports[j].Device {
+			return ports[i].Device < ports[j].Device
+		}
+		return ports[i].Port < ports[j].Port
+	})
+	return ports, nil
+}
+
+// rdmaNetDev returns the netdev of an RDMA port. RoCE ports list it in
+// gid_attrs; otherwise the netdevs on the same PCI function are matched by
+// dev_port (dev_id on old kernels), which is the zero-based port number.
+func (m *Manager) rdmaNetDev(dir string, port int) string {
+	ndev, err := m.fs.ReadString(path.Join(dir, "ports", strconv.Itoa(port), "gid_attrs/ndevs/0"))
+	if err == nil && ndev != "" {
+		return ndev
+	}
+
+	netdevs, err := m.fs.ReadDir(path.Join(dir, "device/net"))
+	if err != nil {
+		return ""
+	}
+	for _, netdev := range netdevs {
+		netDir := path.Join(dir, "device/net", netdev.Name())
+		devPort, err := m.fs.ReadString(path.Join(netDir, "dev_port"))
+		if err != nil || devPort == "0" {
+			// dev_port is 0 on drivers that report the port in dev_id
+			if devID, err := m.fs.ReadString(path.Join(netDir, "dev_id")); err == nil {
+				devPort = devID
+			}
+		}
+		if n, err := strconv.ParseInt(devPort, 0, 32); err == nil && int(n) == port-1 {
+			return netdev.Name()
+		}
+	}
+	return ""
+}
+
+// stripStateCode turns "4: ACTIVE" into "ACTIVE"
+func stripStateCode(state string) string {
+	if i := strings.Index(state, ":"); i >= 0 {
+		return strings.TrimSpace(state[i+1:])
+	}
+	return state
+}
+
+// gidInterfaceID returns the interface ID (lower 64 bits) of a GID, which
+// for GID index 0 of an InfiniBand port is the port GUID
+func gidInterfaceID(gid string) string {
+	parts := strings.Split(gid, ":")
+	if len(parts) != 8 {
+		return ""
+	}
+	return strings.Join(parts[4:], ":")
+}
+
+// IPoIBInfo holds the IPoIB settings of an Infiniband interface
+type IPoIBInfo struct {
+	Mode string // datagram or connected
+	// ib_ipoib module defaults for new interfaces; they are read-only at
+	// runtime, the rings of an existing interface are changed with ethtool -G
+	SendQueueSize int
+	RecvQueueSize int
+}
+
+// GetIPoIBInfo returns the IPoIB mode of an interface and the ib_ipoib
+// queue size defaults
+func (m *Manager) GetIPoIBInfo(name string) (IPoIBInfo, error) {
+	mode, err := m.fs.ReadString(fmt.Sprintf("/sys/class/net/%s/mode", name))
+	if err != nil {
+		return IPoIBInfo{}, err
+	}
+
+	info := IPoIBInfo{Mode: mode}
+	if v, err := m.fs.ReadString("/sys/module/ib_ipoib/parameters/send_queue_size"); err == nil {
+		info.SendQueueSize, _ = strconv.Atoi(v)
+	}
+	if v, err := m.fs.ReadString("/sys/module/ib_ipoib/parameters/recv_queue_size"); err == nil {
+		info.RecvQueueSize, _ = strconv.Atoi(v)
+	}
+	return info, nil
+}
+
+// SetIPoIBMode switches an interface between datagram and connected mode
+func (m *Manager) SetIPoIBMode(name string, mode string) error {
+	err := m.fs.WriteFile(fmt.Sprintf("/sys/class/net/%s/mode", name), []byte(mode))
+	if err != nil {
+		return fmt.Errorf("failed to set IPoIB mode: %v", err)
+	}
+	return nil
+}
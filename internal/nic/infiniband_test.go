@@ -0,0 +1,81 @@
+package nic
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGetRDMAPorts(t *testing.T) {
+	m := newTestManager(t)
+
+	ports, err := m.GetRDMAPorts()
+	if err != nil {
+		t.Fatalf("GetRDMAPorts: %v", err)
+	}
+
+	want := []RDMAPort{
+		{
+			// RoCE ports name their netdev in gid_attrs
+			Device: "mlx5_0", Port: 1, NetDev: "eth0", State: "ACTIVE", PhysState: "LinkUp",
+			Rate: "400 Gb/sec (4X NDR)", LID: "0x0", PortGUID: "0e42:a1ff:fe00:0001",
+			NodeGUID: "0c42:a103:00aa:bb00", FirmwareVersion: "28.39.1002", LinkLayer: "Ethernet",
+		},
+		{
+			// InfiniBand ports are matched to the netdev by dev_port
+			Device: "mlx5_1", Port: 1, NetDev: "ib0", State: "ACTIVE", PhysState: "LinkUp",
+			Rate: "400 Gb/sec (4X NDR)", LID: "0x1a", PortGUID: "0c42:a103:00aa:bb01",
+			NodeGUID: "0c42:a103:00aa:bb00", FirmwareVersion: "28.39.1002", LinkLayer: "InfiniBand",
+		},
+	}
+	if !reflect.DeepEqual(ports, want) {
+		t.Errorf("GetRDMAPorts =\n%+v\nwant\n%+v", ports, want)
+	}
+}
+
+func TestDiscoverRDMA(t *testing.T) {
+	m := newTestManager(t)
+
+	nics, err := m.GetAllNICs(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllNICs: %v", err)
+	}
+	ports := make(map[string]string)
+	for _, n := range nics {
+		if n.RDMA != nil {
+			ports[n.Name] = n.RDMA.Name()
+		}
+	}
+	if want := map[string]string{"eth0": "mlx5_0/1", "ib0": "mlx5_1/1"}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("RDMA ports = %v, want %v", ports, want)
+	}
+}
+
+func TestRateMbps(t *testing.T) {
+	for rate, want := range map[string]int{
+		"400 Gb/sec (4X NDR)": 400000,
+		"53.125 Gb/sec (1X)":  53125,
+		"2.5 Gb/sec (1X SDR)": 2500,
+		"":                    0,
+		"invalid":             0,
+	} {
+		if got := (RDMAPort{Rate: rate}).RateMbps(); got != want {
+			t.Errorf("RateMbps(%q) = %d, want %d", rate, got, want)
+		}
+	}
+}
+
+func TestStateHelpers(t *testing.T) {
+	if got := stripStateCode("4: ACTIVE"); got != "ACTIVE" {
+		t.Errorf("stripStateCode = %q", got)
+	}
+	if got := stripStateCode("ACTIVE"); got != "ACTIVE" {
+		t.Errorf("stripStateCode without a code = %q", got)
+	}
+	if got := gidInterfaceID("fe80:0000:0000:0000:0c42:a103:00aa:bb01"); got != "0c42:a103:00aa:bb01" {
+		t.Errorf("gidInterfaceID = %q", got)
+	}
+	if got := gidInterfaceID("fe80::1"); got != "" {
+		t.Errorf("gidInterfaceID of a short GID = %q", got)
+	}
+}
@@ -1,11 +1,15 @@
 package nic
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"optimize-hpc-nic/internal/config"
 	"optimize-hpc-nic/internal/logger"
 	"optimize-hpc-nic/pkg/system"
 )
@@ -15,6 +19,13 @@ const (
 	ARPHRD_INFINIBAND = 32 // Infiniband
 )
 
+// Where the speed of a NIC was taken from
+const (
+	SpeedSourceLink      = "link"            // negotiated speed of an up link
+	SpeedSourceLinkModes = "supported modes" // highest supported link mode of a down link
+	SpeedSourceRDMA      = "port rate"       // RDMA port rate of an IPoIB netdev
+)
+
 const (
 	NICTypeEthernet   = "Ethernet"
 	NICTypeInfiniband = "Infiniband"
@@ -23,41 +34,145 @@ const (
 
 // NIC represents a network interface
 type NIC struct {
-	Name       string
-	Speed      int
-	Driver     string
-	MAC        string
-	LinkType   string
-	RXCurrent  int
-	TXCurrent  int
-	RXMax      int
-	TXMax      int
-	IsPhysical bool
-	IsOptimal  bool
+	Name        string
+	Speed       int
+	SpeedSource string
+	OperState   string // e.g. up, down, lowerlayerdown
+	Carrier     bool
+	Driver      string
+	DriverInfo  system.DriverInfo
+	MAC         string
+	LinkType    string
+	Ring        system.RingParams // current ring parameters
+	RingMax     system.RingParams // pre-set maximums
+	RingTarget  system.RingParams // RX/TX sizes required by the ring rules
+	RingRule    string            // ring rule that chose RingTarget, "" for the default (max)
+	RingSkip    string            // why RingTarget keeps current sizes the rule sets, "" if none
+	Channels    system.Channels   // current channel counts
+	ChannelsMax system.Channels   // pre-set maximums
+	LocalCPUs   int               // online CPUs on the NIC's NUMA node
+	Coalesce    system.Coalesce
+	Features    map[string]system.Feature
+	Pause       system.PauseParams
+	PrivFlags   map[string]bool
+	// Private flags that differ from the policy, filled in by the optimizer
+	PrivFlagMismatches []string
+	FEC                system.FECParams
+	PCI                PCIInfo
+	RDMA               *RDMAPort // nil if the NIC is not an RDMA port
+	IPoIB              IPoIBInfo
+	Bond               *Membership // nil if not enslaved to a bond or team
+	Function           string      // pf, vf, uplink or vf-rep
+	// Why the NIC is left alone, filled in by the select rules or the optimizer
+	SkipReason string
+	// Required FEC encoding when it is not configured, filled in
+	// by the optimizer
+	FECMismatch string
+	// Offload features that differ from the policy, filled in by the optimizer
+	OffloadMismatches []string
+	// Ring parameters the driver read back differently after a set, filled
+	// in by the optimizer
+	RingMismatches []string
+	IsPhysical     bool
+	IsOptimal      bool
+	// Discovery did not finish in time; only Name is reliable
+	Unresponsive bool
 }
 
 // Manager handles NIC operations
 type Manager struct {
-	minSpeed int
-	log      *logger.Logger
-	ethtool  *system.Ethtool
+	minSpeed    int
+	log         *logger.Logger
+	ethtool     *system.Ethtool
+	fs          *system.FS
+	selector    config.SelectPolicy
+	rings       config.RingPolicy
+	maxWorkers  int
+	nicTimeout  time.Duration // 0 = wait for discovery indefinitely
+	callTimeout time.Duration // bounds reads outside ethtool, 0 = no timeout
 }
 
 // NewManager creates a new NIC manager
-func NewManager(minSpeed int, log *logger.Logger) *Manager {
+func NewManager(cfg *config.Config, log *logger.Logger) (*Manager, error) {
+	runner, err := newRunner(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fixtures only capture ethtool commands, so netlink has to be bypassed
+	backend := cfg.Backend
+	if cfg.RecordFile != "" || cfg.ReplayFile != "" {
+		if backend == system.BackendNetlink {
+			return nil, fmt.Errorf("the netlink backend cannot be used with -record or -replay")
+		}
+		backend = system.BackendExec
+	}
+
+	ethtool, err := system.NewEthtoolBackend(backend, runner, commandTimeout(cfg))
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Using %s ethtool backend", ethtool.Backend())
+
+	fs := system.NewFS(cfg.Root)
+	if fs.Root() != "/" {
+		log.Info("Reading sysfs under %s", fs.Root())
+	}
+
 	return &Manager{
-		minSpeed: minSpeed,
-		log:      log,
-		ethtool:  system.NewEthtool(),
+		minSpeed:    cfg.MinSpeed,
+		log:         log,
+		ethtool:     ethtool,
+		fs:          fs,
+		selector:    cfg.Policy.Select,
+		rings:       cfg.Policy.Ring,
+		maxWorkers:  cfg.MaxWorkers,
+		nicTimeout:  time.Duration(cfg.NICTimeout) * time.Second,
+		callTimeout: commandTimeout(cfg),
+	}, nil
+}
+
+// commandTimeout returns the per-command timeout of the configuration
+func commandTimeout(cfg *config.Config) time.Duration {
+	return time.Duration(cfg.CommandTimeout) * time.Second
+}
+
+// newRunner returns the command runner selected by the configuration
+func newRunner(cfg *config.Config, log *logger.Logger) (system.Runner, error) {
+	switch {
+	case cfg.RecordFile != "" && cfg.ReplayFile != "":
+		return nil, fmt.Errorf("-record and -replay are mutually exclusive")
+	case cfg.ReplayFile != "":
+		fixture, err := system.LoadFixture(cfg.ReplayFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load replay fixture: %v", err)
+		}
+		log.Info("Replaying %d recorded commands from %s (host: %s)", len(fixture.Commands), cfg.ReplayFile, fixture.Host)
+		return system.NewReplayRunner(fixture), nil
+	case cfg.RecordFile != "":
+		log.Info("Recording ethtool commands to %s", cfg.RecordFile)
+		return system.NewRecordingRunner(system.ExecRunner{Timeout: commandTimeout(cfg)}, cfg.RecordFile), nil
+	default:
+		return system.ExecRunner{Timeout: commandTimeout(cfg)}, nil
 	}
 }
 
+// Ethtool returns the ethtool instance used by the manager
+func (m *Manager) Ethtool() *system.Ethtool {
+	return m.ethtool
+}
+
+// FS returns the filesystem used for sysfs reads
+func (m *Manager) FS() *system.FS {
+	return m.fs
+}
+
 // GetAllInterfaces returns a list of all network interfaces
 func (m *Manager) GetAllInterfaces() ([]string, error) {
 	var interfaces []string
 
 	// Open /sys/class/net directory
-	files, err := os.ReadDir("/sys/class/net")
+	files, err := m.fs.ReadDir("/sys/class/net")
 	if err != nil {
 		return nil, fmt.Errorf("error reading network interfaces: %v", err)
 	}
@@ -74,124 +189,430 @@ func (m *Manager) GetAllInterfaces() ([]string, error) {
 }
 
 // IsPhysicalNIC checks if a network interface is a physical device
-func (m *Manager) IsPhysicalNIC(name string) bool {
+func (m *Manager) IsPhysicalNIC(ctx context.Context, name string) bool {
 	// Check if it's a virtual interface
-	if _, err := os.Stat(fmt.Sprintf("/sys/devices/virtual/net/%s", name)); err == nil {
+	if m.fs.Exists(fmt.Sprintf("/sys/devices/virtual/net/%s", name)) {
 		return false
 	}
 
 	// Check if it has a physical device connection
-	if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/device", name)); err == nil {
+	if m.fs.Exists(fmt.Sprintf("/sys/class/net/%s/device", name)) {
 		return true
 	}
 
 	// Check if it has a driver
-	_, err := m.ethtool.GetDriverInfo(name)
+	_, err := m.ethtool.GetDriverInfo(ctx, name)
 	return err == nil
 }
 
-// GetNICSpeed returns the speed of a network interface in Mbps
-func (m *Manager) GetNICSpeed(name string) (int, error) {
+// GetNICSpeed returns the negotiated and the highest supported speed of a
+// network interface in Mbps
+func (m *Manager) GetNICSpeed(ctx context.Context, name string) (system.LinkSpeed, error) {
 	// Try to get speed from ethtool
-	speed, err := m.ethtool.GetSpeed(name)
-	if err == nil && speed > 0 {
+	speed, _ := m.ethtool.GetLinkSpeed(ctx, name)
+	if speed.Current > 0 {
 		return speed, nil
 	}
 
 	// Try to read from system file
 	speedFile := fmt.Sprintf("/sys/class/net/%s/speed", name)
-	if _, err := os.Stat(speedFile); err == nil {
-		data, err := os.ReadFile(speedFile)
+	if m.fs.Exists(speedFile) {
+		data, err := m.fs.ReadFile(speedFile)
 		if err == nil {
 			var speedVal int
-			if _, err := fmt.Sscanf(strings.TrimSpace(string(data)), "%d", &speedVal); err == nil {
-				return speedVal, nil
+			if _, err := fmt.Sscanf(strings.TrimSpace(string(data)), "%d", &speedVal); err == nil && speedVal > 0 {
+				speed.Current = speedVal
 			}
 		}
 	}
 
-	return 0, fmt.Errorf("unable to determine speed for %s", name)
+	if speed == (system.LinkSpeed{}) {
+		return speed, fmt.Errorf("unable to determine speed for %s", name)
+	}
+	return speed, nil
 }
 
 // GetNICMAC returns the MAC address of a network interface
 func (m *Manager) GetNICMAC(name string) (string, error) {
 	macFile := fmt.Sprintf("/sys/class/net/%s/address", name)
-	data, err := os.ReadFile(macFile)
+	data, err := m.fs.ReadFile(macFile)
 	if err != nil {
 		return "", err
 	}
 	return strings.TrimSpace(string(data)), nil
 }
 
-// GetHighSpeedNICs returns a list of all high-speed physical NICs
-func (m *Manager) GetHighSpeedNICs() ([]*NIC, error) {
-	var nics []*NIC
+// GetLinkState returns the operstate and carrier of a network interface.
+// Carrier cannot be read while the interface is administratively down.
+func (m *Manager) GetLinkState(name string) (string, bool) {
+	operstate, _ := m.fs.ReadString(fmt.Sprintf("/sys/class/net/%s/operstate", name))
+	carrier, _ := m.fs.ReadString(fmt.Sprintf("/sys/class/net/%s/carrier", name))
+	return operstate, carrier == "1"
+}
 
+// GetLocalCPUs returns the online CPUs local to the NIC's NUMA node
+func (m *Manager) GetLocalCPUs(name string) ([]int, error) {
+	dir, err := m.pciDevicePath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.fs.ReadFile(path.Join(dir, "local_cpulist"))
+	if err != nil {
+		return nil, err
+	}
+	local, err := system.ParseCPUList(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Offline CPUs cannot service queues
+	data, err = m.fs.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return local, nil
+	}
+	online, err := system.ParseCPUList(string(data))
+	if err != nil {
+		return local, nil
+	}
+	isOnline := make(map[int]bool, len(online))
+	for _, cpu := range online {
+		isOnline[cpu] = true
+	}
+
+	var cpus []int
+	for _, cpu := range local {
+		if isOnline[cpu] {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// GetHighSpeedNICs returns a list of all high-speed physical NICs.
+// Interfaces are discovered in parallel by up to -workers goroutines; a NIC
+// whose discovery outlasts the NIC timeout is returned marked Unresponsive
+// so one wedged driver cannot stall the whole run.
+func (m *Manager) GetHighSpeedNICs(ctx context.Context) ([]*NIC, error) {
+	return m.discover(ctx, false)
+}
+
+// GetAllNICs returns every physical NIC whatever its speed, including NICs
+// the select rules leave out
+func (m *Manager) GetAllNICs(ctx context.Context) ([]*NIC, error) {
+	return m.discover(ctx, true)
+}
+
+// discover discovers the physical NICs, all of them or only the selected
+// ones
+func (m *Manager) discover(ctx context.Context, all bool) ([]*NIC, error) {
 	// Get all interfaces
 	interfaces, err := m.GetAllInterfaces()
 	if err != nil {
 		return nil, err
 	}
 
-	// Process each interface
-	for _, iface := range interfaces {
-		if m.IsPhysicalNIC(iface) {
-			nic := &NIC{
-				Name:       iface,
-				IsPhysical: true,
-			}
-			// Get link type
-			linkType, err := m.GetNICLinkType(iface)
-			if err == nil {
-				nic.LinkType = linkType
-			} else {
-				nic.LinkType = NICTypeUnknown
+	// Map RDMA ports to their netdevs; hosts without RDMA devices have none
+	rdmaPorts := make(map[string]RDMAPort)
+	ports, err := m.GetRDMAPorts()
+	if err == nil {
+		for _, port := range ports {
+			if port.NetDev != "" {
+				rdmaPorts[port.NetDev] = port
 			}
+		}
+	}
 
-			// Get speed
-			speed, err := m.GetNICSpeed(iface)
-			if err == nil {
-				nic.Speed = speed
-			}
+	// Process each interface, keeping the results in interface order
+	found := make([]*NIC, len(interfaces))
+	sem := make(chan struct{}, m.maxWorkers)
+	var wg sync.WaitGroup
+dispatch:
+	for i, iface := range interfaces {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+		wg.Add(1)
+		go func(i int, iface string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			found[i] = m.discoverWithTimeout(ctx, iface, rdmaPorts, all)
+		}(i, iface)
+	}
+	wg.Wait()
 
-			// Only add high-speed NICs
-			if nic.Speed >= m.minSpeed {
-				// Get MAC address
-				mac, err := m.GetNICMAC(iface)
-				if err == nil {
-					nic.MAC = mac
-				}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-				// Get driver
-				driver, err := m.ethtool.GetDriverInfo(iface)
-				if err == nil {
-					nic.Driver = driver
-				}
+	var nics []*NIC
+	for _, nic := range found {
+		if nic != nil {
+			nics = append(nics, nic)
+		}
+	}
+	return nics, nil
+}
 
-				// Get ring buffer settings
-				rxCur, txCur, rxMax, txMax, err := m.ethtool.GetRingBufferSettings(iface)
-				if err == nil {
-					nic.RXCurrent = rxCur
-					nic.TXCurrent = txCur
-					nic.RXMax = rxMax
-					nic.TXMax = txMax
-					nic.IsOptimal = (rxCur == rxMax && txCur == txMax)
-				}
+// discoverWithTimeout runs discoverNIC, giving up once the NIC timeout
+// expires or ctx is cancelled. Giving up cancels the discovery, which kills
+// its running ethtool command and fails the ones after it; only a sysfs read
+// stuck in the driver keeps the discovery running until the read returns,
+// and its result is dropped.
+func (m *Manager) discoverWithTimeout(ctx context.Context, iface string, rdmaPorts map[string]RDMAPort, all bool) *NIC {
+	if m.nicTimeout <= 0 {
+		nic := m.discoverNIC(ctx, iface, rdmaPorts, all)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return nic
+	}
 
-				nics = append(nics, nic)
-			}
+	nicCtx, cancel := context.WithTimeout(ctx, m.nicTimeout)
+	defer cancel()
+
+	done := make(chan *NIC, 1)
+	go func() {
+		done <- m.discoverNIC(nicCtx, iface, rdmaPorts, all)
+	}()
+
+	var nic *NIC
+	select {
+	case nic = <-done:
+	case <-nicCtx.Done():
+	}
+
+	// A discovery finishing as it is cancelled read nothing reliable
+	switch {
+	case ctx.Err() != nil:
+		return nil
+	case nicCtx.Err() != nil:
+		m.log.Error("Discovery of %s did not finish within %v, marking it unresponsive", iface, m.nicTimeout)
+		return &NIC{
+			Name:         iface,
+			LinkType:     NICTypeUnknown,
+			IsPhysical:   true,
+			Unresponsive: true,
+			SkipReason:   fmt.Sprintf("discovery did not finish within %v", m.nicTimeout),
 		}
+	default:
+		return nic
+	}
+}
+
+// discoverNIC reads the state of one interface. It returns nil for
+// interfaces that are not physical, or not selected unless all is set.
+func (m *Manager) discoverNIC(ctx context.Context, iface string, rdmaPorts map[string]RDMAPort, all bool) *NIC {
+	if !m.IsPhysicalNIC(ctx, iface) {
+		return nil
 	}
 
-	return nics, nil
+	nic := &NIC{
+		Name:       iface,
+		IsPhysical: true,
+		Function:   m.GetFunctionClass(iface),
+	}
+	// Get link type
+	linkType, err := m.GetNICLinkType(iface)
+	if err == nil {
+		nic.LinkType = linkType
+	} else {
+		nic.LinkType = NICTypeUnknown
+	}
+
+	// Get speed; a down link has none, so it is classified by what it is
+	// capable of and optimized before it comes up
+	speed, err := m.GetNICSpeed(ctx, iface)
+	if err == nil && speed.Current > 0 {
+		nic.Speed = speed.Current
+		nic.SpeedSource = SpeedSourceLink
+	} else if err == nil && speed.Supported > 0 {
+		nic.Speed = speed.Supported
+		nic.SpeedSource = SpeedSourceLinkModes
+	}
+
+	// Get link state
+	nic.OperState, nic.Carrier = m.GetLinkState(iface)
+
+	// IPoIB netdevs often report neither; use the port rate instead
+	if port, ok := rdmaPorts[iface]; ok {
+		nic.RDMA = &port
+		if nic.Speed <= 0 && port.RateMbps() > 0 {
+			nic.Speed = port.RateMbps()
+			nic.SpeedSource = SpeedSourceRDMA
+		}
+	}
+
+	// Only add high-speed NICs and NICs included by the select rules
+	selected := all || nic.Speed >= m.minSpeed
+	if selected || m.selector.Active() {
+		m.getIdentity(ctx, nic)
+		selected = m.applySelect(nic, selected)
+	}
+
+	if !selected {
+		return nil
+	}
+
+	// Get ring buffer settings
+	cur, max, err := m.ethtool.GetRingBufferSettings(ctx, iface)
+	if err == nil {
+		nic.Ring = cur
+		nic.RingMax = max
+		nic.RingTarget, nic.RingRule = m.ringTarget(nic)
+		nic.IsOptimal = (cur.RX == nic.RingTarget.RX && cur.TX == nic.RingTarget.TX)
+	}
+
+	// Get channel counts
+	curCh, maxCh, err := m.ethtool.GetChannels(ctx, iface)
+	if err == nil {
+		nic.Channels = curCh
+		nic.ChannelsMax = maxCh
+	}
+
+	// Get interrupt coalescing
+	coalesce, err := m.ethtool.GetCoalesce(ctx, iface)
+	if err == nil {
+		nic.Coalesce = coalesce
+	}
+
+	// Get offload features
+	features, err := m.ethtool.GetFeatures(ctx, iface)
+	if err == nil {
+		nic.Features = features
+	}
+
+	// Get pause frame settings
+	pause, err := m.ethtool.GetPause(ctx, iface)
+	if err == nil {
+		nic.Pause = pause
+	}
+
+	// Get driver private flags
+	privFlags, err := m.ethtool.GetPrivFlags(ctx, iface)
+	if err == nil {
+		nic.PrivFlags = privFlags
+	}
+
+	// Get FEC settings
+	fec, err := m.ethtool.GetFEC(ctx, iface)
+	if err == nil {
+		nic.FEC = fec
+	}
+
+	// Get IPoIB mode and queue defaults
+	if nic.LinkType == NICTypeInfiniband {
+		ipoib, err := m.GetIPoIBInfo(iface)
+		if err == nil {
+			nic.IPoIB = ipoib
+		}
+	}
+
+	// Get bond or team membership
+	bond, err := m.GetMembership(ctx, iface)
+	if err == nil {
+		nic.Bond = bond
+	}
+
+	// Get NUMA-local CPUs
+	cpus, err := m.GetLocalCPUs(iface)
+	if err == nil {
+		nic.LocalCPUs = len(cpus)
+	}
+
+	return nic
+}
+
+// getIdentity fills in the attributes the select rules match against
+func (m *Manager) getIdentity(ctx context.Context, nic *NIC) {
+	// Get MAC address
+	mac, err := m.GetNICMAC(nic.Name)
+	if err == nil {
+		nic.MAC = mac
+	}
+
+	// Get driver
+	driver, err := m.ethtool.GetDriverInfo(ctx, nic.Name)
+	if err == nil {
+		nic.Driver = driver.Driver
+		nic.DriverInfo = driver
+	}
+
+	// Get PCI address, NUMA node and PCIe link state
+	pci, err := m.GetPCIInfo(nic.Name)
+	if err == nil {
+		nic.PCI = pci
+	}
+}
+
+// applySelect applies the select rules to a NIC and reports whether it is
+// handled. Excluded NICs are still returned so the reason can be shown.
+func (m *Manager) applySelect(nic *NIC, selected bool) bool {
+	target := selectTarget(nic)
+
+	if !selected {
+		rule, ok := m.selector.Included(target)
+		if !ok {
+			return false
+		}
+		m.log.Debug("Including %s (%dMbps) by %s", nic.Name, nic.Speed, rule)
+	}
+	if rule, ok := m.selector.Excluded(target); ok {
+		nic.SkipReason = "excluded by " + rule
+	}
+	return true
+}
+
+// ringTarget resolves the RX/TX sizes the ring rules require for a NIC
+// against its pre-set maximums. Sizes a rule leaves unset, and sizes that
+// cannot be resolved, keep the current value; the reason for the latter is
+// kept in RingSkip.
+func (m *Manager) ringTarget(nic *NIC) (system.RingParams, string) {
+	rx, tx, rule := m.rings.RingSizeFor(selectTarget(nic))
+
+	var target system.RingParams
+	var skips []string
+	for _, r := range []struct {
+		name     string
+		size     config.RingSize
+		cur, max int
+		dst      *int
+	}{
+		{"rx", rx, nic.Ring.RX, nic.RingMax.RX, &target.RX},
+		{"tx", tx, nic.Ring.TX, nic.RingMax.TX, &target.TX},
+	} {
+		var err error
+		if *r.dst, err = r.size.Resolve(r.cur, r.max); err != nil {
+			skips = append(skips, fmt.Sprintf("%s ring left unchanged: %v", r.name, err))
+		}
+	}
+	nic.RingSkip = strings.Join(skips, "; ")
+	if nic.RingSkip != "" {
+		m.log.Info("Not sizing rings of %s: %s", nic.Name, nic.RingSkip)
+	}
+	return target, rule
+}
+
+// selectTarget returns the attributes select and ring rules match against
+func selectTarget(nic *NIC) config.SelectTarget {
+	return config.SelectTarget{
+		Name:     nic.Name,
+		Driver:   nic.Driver,
+		PCIID:    nic.PCI.ID(),
+		PCISlot:  nic.PCI.Address,
+		MAC:      nic.MAC,
+		LinkType: nic.LinkType,
+		Speed:    nic.Speed,
+	}
 }
 
 // 添加获取网卡链路层类型的方法
 func (m *Manager) GetNICLinkType(name string) (string, error) {
 	// 方法1: 检查接口类型文件
 	typeFile := fmt.Sprintf("/sys/class/net/%s/type", name)
-	if _, err := os.Stat(typeFile); err == nil {
-		data, err := os.ReadFile(typeFile)
+	if m.fs.Exists(typeFile) {
+		data, err := m.fs.ReadFile(typeFile)
 		if err == nil {
 			typeVal, err := strconv.Atoi(strings.TrimSpace(string(data)))
 			if err == nil {
@@ -0,0 +1,150 @@
+package nic
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"optimize-hpc-nic/pkg/system"
+)
+
+// pciAddress matches a PCI BDF such as 0000:3b:00.0. Domains behind VMD or
+// Hyper-V are wider than four digits, e.g. 10000:00:02.0.
+var pciAddress = regexp.MustCompile(`^[0-9a-f]{4,}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// PCIInfo describes the PCI function behind a network interface
+type PCIInfo struct {
+	Address      string // domain:bus:device.function
+	Vendor       string // e.g. 15b3
+	Device       string // e.g. 1021
+	NUMANode     int    // -1 if the platform does not report one
+	LocalCPUList string
+	LinkSpeed    string // e.g. "32.0 GT/s PCIe"
+	LinkWidth    int
+	MaxLinkSpeed string
+	MaxLinkWidth int
+}
+
+// ID returns the vendor:device ID
+func (p PCIInfo) ID() string {
+	if p.Vendor == "" {
+		return ""
+	}
+	return p.Vendor + ":" + p.Device
+}
+
+// Degraded reports whether the PCIe link trained below its maximum speed
+// or width
+func (p PCIInfo) Degraded() bool {
+	if p.LinkWidth > 0 && p.MaxLinkWidth > 0 && p.LinkWidth < p.MaxLinkWidth {
+		return true
+	}
+	cur, curOK := linkSpeedGTs(p.LinkSpeed)
+	max, maxOK := linkSpeedGTs(p.MaxLinkSpeed)
+	return curOK && maxOK && cur < max
+}
+
+// linkSpeedGTs parses the GT/s value of a sysfs link speed
+func linkSpeedGTs(speed string) (float64, bool) {
+	fields := strings.Fields(speed)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	return v, err == nil
+}
+
+// pciDevicePath returns the sysfs directory of the PCI function behind a
+// network interface. Virtual buses such as virtio sit between the netdev
+// and the PCI function, so the device path is walked up to the first BDF.
+func (m *Manager) pciDevicePath(name string) (string, error) {
+	dev, err := m.fs.Resolve(fmt.Sprintf("/sys/class/net/%s/device", name))
+	if err != nil {
+		return "", err
+	}
+	for p := dev; p != "/"; p = path.Dir(p) {
+		if pciAddress.MatchString(path.Base(p)) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not a PCI device", name)
+}
+
+// GetPCIInfo returns the PCI address, IDs, NUMA locality and PCIe link
+// state of a network interface
+func (m *Manager) GetPCIInfo(name string) (PCIInfo, error) {
+	dir, err := m.pciDevicePath(name)
+	if err != nil {
+		return PCIInfo{}, err
+	}
+
+	read := func(attr string) string {
+		v, _ := m.fs.ReadString(path.Join(dir, attr))
+		return v
+	}
+	readInt := func(attr string, def int) int {
+		v, err := strconv.Atoi(read(attr))
+		if err != nil {
+			return def
+		}
+		return v
+	}
+
+	return PCIInfo{
+		Address:      path.Base(dir),
+		Vendor:       strings.TrimPrefix(read("vendor"), "0x"),
+		Device:       strings.TrimPrefix(read("device"), "0x"),
+		NUMANode:     readInt("numa_node", -1),
+		LocalCPUList: read("local_cpulist"),
+		LinkSpeed:    read("current_link_speed"),
+		LinkWidth:    readInt("current_link_width", 0),
+		MaxLinkSpeed: read("max_link_speed"),
+		MaxLinkWidth: readInt("max_link_width", 0),
+	}, nil
+}
+
+// GetVPD returns the Vital Product Data of the PCI function behind a
+// network interface, bounded by ctx and the per-call timeout. Reading VPD
+// requires root. A read stuck in the driver cannot be interrupted and is
+// left to finish on its own.
+func (m *Manager) GetVPD(ctx context.Context, name string) (system.VPD, error) {
+	if m.callTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.callTimeout)
+		defer cancel()
+	}
+
+	type result struct {
+		vpd system.VPD
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		vpd, err := m.readVPD(name)
+		done <- result{vpd, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.vpd, r.err
+	case <-ctx.Done():
+		return system.VPD{}, fmt.Errorf("reading VPD of %s: %w", name, ctx.Err())
+	}
+}
+
+// readVPD reads and parses the vpd file of the PCI function behind a
+// network interface
+func (m *Manager) readVPD(name string) (system.VPD, error) {
+	dir, err := m.pciDevicePath(name)
+	if err != nil {
+		return system.VPD{}, err
+	}
+	data, err := m.fs.ReadFile(path.Join(dir, "vpd"))
+	if err != nil {
+		return system.VPD{}, err
+	}
+	return system.ParseVPD(data)
+}
@@ -0,0 +1,113 @@
+package nic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetPCIInfo(t *testing.T) {
+	m := newTestManager(t)
+
+	tests := []struct {
+		name     string
+		want     PCIInfo
+		degraded bool
+	}{
+		{
+			name: "eth0",
+			want: PCIInfo{
+				Address: "0000:3b:00.0", Vendor: "15b3", Device: "1021", NUMANode: 0, LocalCPUList: "0-3",
+				LinkSpeed: "32.0 GT/s PCIe", LinkWidth: 8, MaxLinkSpeed: "32.0 GT/s PCIe", MaxLinkWidth: 16,
+			},
+			degraded: true,
+		},
+		{
+			name: "eth1",
+			want: PCIInfo{
+				Address: "10000:01:00.0", Vendor: "8086", Device: "159b", NUMANode: 1, LocalCPUList: "4-7",
+				LinkSpeed: "16.0 GT/s PCIe", LinkWidth: 16, MaxLinkSpeed: "16.0 GT/s PCIe", MaxLinkWidth: 16,
+			},
+		},
+		{
+			// A representor shares the PCI function of its PF
+			name: "pf0vf0",
+			want: PCIInfo{
+				Address: "0000:3b:00.0", Vendor: "15b3", Device: "1021", NUMANode: 0, LocalCPUList: "0-3",
+				LinkSpeed: "32.0 GT/s PCIe", LinkWidth: 8, MaxLinkSpeed: "32.0 GT/s PCIe", MaxLinkWidth: 16,
+			},
+			degraded: true,
+		},
+		{
+			// Without PCIe link attributes nothing is degraded
+			name: "eth2",
+			want: PCIInfo{Address: "0000:3b:00.2", Vendor: "15b3", Device: "101e", NUMANode: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.GetPCIInfo(tt.name)
+			if err != nil {
+				t.Fatalf("GetPCIInfo: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetPCIInfo = %+v, want %+v", got, tt.want)
+			}
+			if got.Degraded() != tt.degraded {
+				t.Errorf("Degraded() = %v, want %v", got.Degraded(), tt.degraded)
+			}
+		})
+	}
+
+	if _, err := m.GetPCIInfo("bond0"); err == nil {
+		t.Error("GetPCIInfo(bond0) succeeded for a virtual interface")
+	}
+}
+
+func TestPCIAddress(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"0000:3b:00.0":  true,
+		"10000:01:00.0": true,
+		"0000:3b:00.8":  false,
+		"000:3b:00.0":   false,
+		"pci10000:00":   false,
+		"0000:3B:00.0":  false,
+	} {
+		if got := pciAddress.MatchString(addr); got != want {
+			t.Errorf("pciAddress.MatchString(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestDegraded(t *testing.T) {
+	tests := []struct {
+		name string
+		pci  PCIInfo
+		want bool
+	}{
+		{"full link", PCIInfo{LinkSpeed: "32.0 GT/s PCIe", LinkWidth: 16, MaxLinkSpeed: "32.0 GT/s PCIe", MaxLinkWidth: 16}, false},
+		{"narrow", PCIInfo{LinkSpeed: "32.0 GT/s PCIe", LinkWidth: 8, MaxLinkSpeed: "32.0 GT/s PCIe", MaxLinkWidth: 16}, true},
+		{"slow", PCIInfo{LinkSpeed: "16.0 GT/s PCIe", LinkWidth: 16, MaxLinkSpeed: "32.0 GT/s PCIe", MaxLinkWidth: 16}, true},
+		{"unknown speed", PCIInfo{LinkSpeed: "Unknown", LinkWidth: 16, MaxLinkSpeed: "32.0 GT/s PCIe", MaxLinkWidth: 16}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.pci.Degraded(); got != tt.want {
+			t.Errorf("%s: Degraded() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetLocalCPUs(t *testing.T) {
+	m := newTestManager(t)
+
+	// CPUs 6 and 7 of eth1's NUMA node are offline
+	for name, want := range map[string][]int{
+		"eth0": {0, 1, 2, 3},
+		"eth1": {4, 5},
+	} {
+		got, err := m.GetLocalCPUs(name)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("GetLocalCPUs(%s) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+}
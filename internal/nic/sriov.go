@@ -0,0 +1,42 @@
+package nic
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Function classes of a netdev
+const (
+	FunctionPF     = "pf"     // physical function without a switchdev eswitch
+	FunctionVF     = "vf"     // SR-IOV virtual function
+	FunctionUplink = "uplink" // uplink representor: the PF netdev in switchdev mode
+	FunctionVFRep  = "vf-rep" // representor of a VF (or of a host PF on BlueField)
+)
+
+// uplinkPortName matches the phys_port_name of uplink representors, e.g. p0
+var uplinkPortName = regexp.MustCompile(`^p[0-9]+$`)
+
+// GetFunctionClass classifies an interface as PF, VF, uplink representor or
+// VF representor. VFs have a physfn link; representors belong to an eswitch
+// (phys_switch_id) and are told apart by their phys_port_name: p0 for the
+// uplink, pf0vf1 or c1pf0vf1 for VFs, pf0hpf for BlueField host PFs.
+func (m *Manager) GetFunctionClass(name string) string {
+	if m.fs.Exists(fmt.Sprintf("/sys/class/net/%s/device/physfn", name)) {
+		return FunctionVF
+	}
+
+	// Both attributes fail to read on NICs that are not in switchdev mode
+	switchID, _ := m.fs.ReadString(fmt.Sprintf("/sys/class/net/%s/phys_switch_id", name))
+	portName, _ := m.fs.ReadString(fmt.Sprintf("/sys/class/net/%s/phys_port_name", name))
+	if switchID == "" || portName == "" {
+		return FunctionPF
+	}
+	if uplinkPortName.MatchString(portName) {
+		return FunctionUplink
+	}
+	if strings.Contains(portName, "pf") {
+		return FunctionVFRep
+	}
+	return FunctionPF
+}
@@ -0,0 +1,19 @@
+package nic
+
+import "testing"
+
+func TestGetFunctionClass(t *testing.T) {
+	m := newTestManager(t)
+
+	for name, want := range map[string]string{
+		"eth0":   FunctionUplink, // p0 on an eswitch
+		"pf0vf0": FunctionVFRep,
+		"eth2":   FunctionVF, // physfn link
+		"eth1":   FunctionPF, // not in switchdev mode
+		"ib0":    FunctionPF,
+	} {
+		if got := m.GetFunctionClass(name); got != want {
+			t.Errorf("GetFunctionClass(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package nic
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"optimize-hpc-nic/internal/config"
+	"optimize-hpc-nic/internal/logger"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// Directories of the PCI functions in the test sysfs tree
+const (
+	cx7Port1 = "/sys/devices/pci0000:00/0000:00:02.0/0000:3b:00.0"
+	cx7Port2 = "/sys/devices/pci0000:00/0000:00:02.0/0000:3b:00.1"
+	cx7VF    = "/sys/devices/pci0000:00/0000:00:02.0/0000:3b:00.2"
+	// An E810 behind Intel VMD, whose PCI domain is five digits wide
+	e810 = "/sys/devices/pci0000:00/0000:00:0e.5/pci10000:00/10000:00:02.0/10000:01:00.0"
+)
+
+// testSysfsFiles holds the attributes of a GPU node with a dual-port
+// ConnectX-7 (eth0 in bond0 and ib0), a VF of eth0 with its representor,
+// and an E810 behind VMD
+var testSysfsFiles = map[string]string{
+	"/sys/devices/system/cpu/online":                  "0-5",
+	"/sys/module/ib_ipoib/parameters/send_queue_size": "256",
+	"/sys/module/ib_ipoib/parameters/recv_queue_size": "512",
+
+	cx7Port1 + "/vendor":             "0x15b3",
+	cx7Port1 + "/device":             "0x1021",
+	cx7Port1 + "/numa_node":          "0",
+	cx7Port1 + "/local_cpulist":      "0-3",
+	cx7Port1 + "/current_link_speed": "32.0 GT/s PCIe",
+	cx7Port1 + "/current_link_width": "8",
+	cx7Port1 + "/max_link_speed":     "32.0 GT/s PCIe",
+	cx7Port1 + "/max_link_width":     "16",
+
+	cx7Port1 + "/net/eth0/type":                     "1",
+	cx7Port1 + "/net/eth0/address":                  "0c:42:a1:00:00:01",
+	cx7Port1 + "/net/eth0/operstate":                "down",
+	cx7Port1 + "/net/eth0/carrier":                  "0",
+	cx7Port1 + "/net/eth0/dev_port":                 "0",
+	cx7Port1 + "/net/eth0/phys_switch_id":           "0c42a10000010000",
+	cx7Port1 + "/net/eth0/phys_port_name":           "p0",
+	cx7Port1 + "/net/eth0/bonding_slave/mii_status": "down",
+	cx7Port1 + "/net/eth0/bonding_slave/state":      "backup",
+
+	cx7Port1 + "/net/pf0vf0/type":           "1",
+	cx7Port1 + "/net/pf0vf0/address":        "8e:1b:5c:00:00:01",
+	cx7Port1 + "/net/pf0vf0/operstate":      "up",
+	cx7Port1 + "/net/pf0vf0/carrier":        "1",
+	cx7Port1 + "/net/pf0vf0/phys_switch_id": "0c42a10000010000",
+	cx7Port1 + "/net/pf0vf0/phys_port_name": "pf0vf0",
+
+	cx7Port1 + "/infiniband/mlx5_0/fw_ver":                    "28.39.1002",
+	cx7Port1 + "/infiniband/mlx5_0/node_guid":                 "0c42:a103:00aa:bb00",
+	cx7Port1 + "/infiniband/mlx5_0/ports/1/state":             "4: ACTIVE",
+	cx7Port1 + "/infiniband/mlx5_0/ports/1/phys_state":        "5: LinkUp",
+	cx7Port1 + "/infiniband/mlx5_0/ports/1/rate":              "400 Gb/sec (4X NDR)",
+	cx7Port1 + "/infiniband/mlx5_0/ports/1/lid":               "0x0",
+	cx7Port1 + "/infiniband/mlx5_0/ports/1/link_layer":        "Ethernet",
+	cx7Port1 + "/infiniband/mlx5_0/ports/1/gids/0":            "fe80:0000:0000:0000:0e42:a1ff:fe00:0001",
+	cx7Port1 + "/infiniband/mlx5_0/ports/1/gid_attrs/ndevs/0": "eth0",
+
+	cx7Port2 + "/vendor":        "0x15b3",
+	cx7Port2 + "/device":        "0x1021",
+	cx7Port2 + "/numa_node":     "0",
+	cx7Port2 + "/local_cpulist": "0-3",
+
+	cx7Port2 + "/net/ib0/type":      "32",
+	cx7Port2 + "/net/ib0/address":   "00:00:10:87:fe:80:00:00:00:00:00:00:0c:42:a1:03:00:aa:bb:01",
+	cx7Port2 + "/net/ib0/operstate": "up",
+	cx7Port2 + "/net/ib0/carrier":   "1",
+	cx7Port2 + "/net/ib0/dev_port":  "0",
+	cx7Port2 + "/net/ib0/mode":      "datagram",
+
+	cx7Port2 + "/infiniband/mlx5_1/fw_ver":             "28.39.1002",
+	cx7Port2 + "/infiniband/mlx5_1/node_guid":          "0c42:a103:00aa:bb00",
+	cx7Port2 + "/infiniband/mlx5_1/ports/1/state":      "4: ACTIVE",
+	cx7Port2 + "/infiniband/mlx5_1/ports/1/phys_state": "5: LinkUp",
+	cx7Port2 + "/infiniband/mlx5_1/ports/1/rate":       "400 Gb/sec (4X NDR)",
+	cx7Port2 + "/infiniband/mlx5_1/ports/1/lid":        "0x1a",
+	cx7Port2 + "/infiniband/mlx5_1/ports/1/link_layer": "InfiniBand",
+	cx7Port2 + "/infiniband/mlx5_1/ports/1/gids/0":     "fe80:0000:0000:0000:0c42:a103:00aa:bb01",
+
+	cx7VF + "/vendor":             "0x15b3",
+	cx7VF + "/device":             "0x101e",
+	cx7VF + "/numa_node":          "0",
+	cx7VF + "/net/eth2/type":      "1",
+	cx7VF + "/net/eth2/address":   "8e:1b:5c:00:00:02",
+	cx7VF + "/net/eth2/operstate": "up",
+	cx7VF + "/net/eth2/carrier":   "1",
+	cx7VF + "/net/eth2/speed":     "400000",
+
+	e810 + "/vendor":             "0x8086",
+	e810 + "/device":             "0x159b",
+	e810 + "/numa_node":          "1",
+	e810 + "/local_cpulist":      "4-7",
+	e810 + "/current_link_speed": "16.0 GT/s PCIe",
+	e810 + "/current_link_width": "16",
+	e810 + "/max_link_speed":     "16.0 GT/s PCIe",
+	e810 + "/max_link_width":     "16",
+	e810 + "/net/eth1/type":      "1",
+	e810 + "/net/eth1/address":   "b4:96:91:00:00:01",
+	e810 + "/net/eth1/operstate": "up",
+	e810 + "/net/eth1/carrier":   "1",
+	e810 + "/net/eth1/speed":     "100000",
+
+	"/sys/devices/virtual/net/bond0/type":         "1",
+	"/sys/devices/virtual/net/bond0/operstate":    "up",
+	"/sys/devices/virtual/net/bond0/bonding/mode": "802.3ad 4",
+	"/sys/devices/virtual/net/lo/type":            "772",
+}
+
+// testSysfsLinks holds the symlinks of the tree, relative like the kernel's
+var testSysfsLinks = map[string]string{
+	"/sys/class/net/eth0":   "../../devices/pci0000:00/0000:00:02.0/0000:3b:00.0/net/eth0",
+	"/sys/class/net/pf0vf0": "../../devices/pci0000:00/0000:00:02.0/0000:3b:00.0/net/pf0vf0",
+	"/sys/class/net/ib0":    "../../devices/pci0000:00/0000:00:02.0/0000:3b:00.1/net/ib0",
+	"/sys/class/net/eth2":   "../../devices/pci0000:00/0000:00:02.0/0000:3b:00.2/net/eth2",
+	"/sys/class/net/eth1":   "../../devices/pci0000:00/0000:00:0e.5/pci10000:00/10000:00:02.0/10000:01:00.0/net/eth1",
+	"/sys/class/net/bond0":  "../../devices/virtual/net/bond0",
+	"/sys/class/net/lo":     "../../devices/virtual/net/lo",
+
+	"/sys/class/infiniband/mlx5_0": "../../devices/pci0000:00/0000:00:02.0/0000:3b:00.0/infiniband/mlx5_0",
+	"/sys/class/infiniband/mlx5_1": "../../devices/pci0000:00/0000:00:02.0/0000:3b:00.1/infiniband/mlx5_1",
+
+	cx7Port1 + "/net/eth0/device":          "../../../0000:3b:00.0",
+	cx7Port1 + "/net/eth0/master":          "../../../../../virtual/net/bond0",
+	cx7Port1 + "/net/pf0vf0/device":        "../../../0000:3b:00.0",
+	cx7Port1 + "/infiniband/mlx5_0/device": "../../../0000:3b:00.0",
+	cx7Port1 + "/virtfn0":                  "../0000:3b:00.2",
+	cx7Port2 + "/net/ib0/device":           "../../../0000:3b:00.1",
+	cx7Port2 + "/infiniband/mlx5_1/device": "../../../0000:3b:00.1",
+	cx7VF + "/net/eth2/device":             "../../../0000:3b:00.2",
+	cx7VF + "/physfn":                      "../0000:3b:00.0",
+	e810 + "/net/eth1/device":              "../../../10000:01:00.0",
+}
+
+// writeTestSysfs writes the test sysfs tree under a new directory and
+// returns it
+func writeTestSysfs(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range testSysfsFiles {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range testSysfsLinks {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// newTestManager returns a Manager reading the test sysfs tree through
+// -root and replaying the recorded mlx5 ethtool fixture
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	cfg := &config.Config{
+		Root:       writeTestSysfs(t),
+		ReplayFile: "../../pkg/system/testdata/mlx5.json",
+		Backend:    system.BackendExec,
+		MinSpeed:   config.DefaultMinSpeed,
+		MaxWorkers: 2,
+	}
+	log := logger.New(filepath.Join(t.TempDir(), "test.log"), 1, 1, 1, false)
+	m, err := NewManager(cfg, log)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return m
+}
+
+func TestDiscoverUnderRoot(t *testing.T) {
+	m := newTestManager(t)
+
+	nics, err := m.GetAllNICs(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllNICs: %v", err)
+	}
+
+	// bond0 and lo are virtual
+	var names []string
+	byName := make(map[string]*NIC)
+	for _, n := range nics {
+		names = append(names, n.Name)
+		byName[n.Name] = n
+	}
+	if want := []string{"eth0", "eth1", "eth2", "ib0", "pf0vf0"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("discovered %v, want %v", names, want)
+	}
+
+	// eth0's link is down, so it is classified by its supported link modes
+	eth0 := byName["eth0"]
+	if eth0.Speed != 400000 || eth0.SpeedSource != SpeedSourceLinkModes {
+		t.Errorf("eth0 speed = %d from %s", eth0.Speed, eth0.SpeedSource)
+	}
+	if eth0.LinkType != NICTypeEthernet || eth0.Driver != "mlx5_core" || eth0.MAC != "0c:42:a1:00:00:01" {
+		t.Errorf("eth0 = %s %s %s", eth0.LinkType, eth0.Driver, eth0.MAC)
+	}
+	if eth0.OperState != "down" || eth0.Carrier {
+		t.Errorf("eth0 link state = %s, carrier %v", eth0.OperState, eth0.Carrier)
+	}
+	if eth0.RingMax.RX != 8192 || eth0.Ring.RX != 1024 || eth0.IsOptimal {
+		t.Errorf("eth0 rings = %+v of %+v, optimal %v", eth0.Ring, eth0.RingMax, eth0.IsOptimal)
+	}
+	if eth0.ChannelsMax.Combined != 63 || eth0.Channels.Combined != 8 {
+		t.Errorf("eth0 channels = %+v of %+v", eth0.Channels, eth0.ChannelsMax)
+	}
+	if eth0.LocalCPUs != 4 {
+		t.Errorf("eth0 local CPUs = %d, want 4", eth0.LocalCPUs)
+	}
+
+	ib0 := byName["ib0"]
+	if ib0.LinkType != NICTypeInfiniband || ib0.Speed != 400000 || ib0.IPoIB.Mode != "datagram" {
+		t.Errorf("ib0 = %s %d %q", ib0.LinkType, ib0.Speed, ib0.IPoIB.Mode)
+	}
+	if ib0.IPoIB.SendQueueSize != 256 || ib0.IPoIB.RecvQueueSize != 512 {
+		t.Errorf("ib0 queue defaults = %d/%d", ib0.IPoIB.SendQueueSize, ib0.IPoIB.RecvQueueSize)
+	}
+
+	// Nothing of eth1 is recorded, so its speed comes from sysfs
+	eth1 := byName["eth1"]
+	if eth1.Speed != 100000 || eth1.SpeedSource != SpeedSourceLink {
+		t.Errorf("eth1 speed = %d from %s", eth1.Speed, eth1.SpeedSource)
+	}
+}
+
+func TestDiscoverSelectedUnderRoot(t *testing.T) {
+	m := newTestManager(t)
+
+	// eth1 is slower than the default minimum of 200G; eth0 and eth2 are
+	// only as fast as their link modes or sysfs report
+	nics, err := m.GetHighSpeedNICs(context.Background())
+	if err != nil {
+		t.Fatalf("GetHighSpeedNICs: %v", err)
+	}
+	var names []string
+	for _, n := range nics {
+		names = append(names, n.Name)
+	}
+	if want := []string{"eth0", "eth2", "ib0"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("discovered %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,29 @@
+package ringbuffer
+
+import (
+	"optimize-hpc-nic/internal/nic"
+)
+
+// groupByMaster splits NICs into units of work. Slaves of the same bond or
+// team form one unit so they are changed one at a time; every other NIC is
+// a unit of its own.
+func groupByMaster(nics []*nic.NIC) [][]*nic.NIC {
+	var units [][]*nic.NIC
+	byMaster := make(map[string]int)
+
+	for _, n := range nics {
+		if n.Bond == nil {
+			units = append(units, []*nic.NIC{n})
+			continue
+		}
+		i, ok := byMaster[n.Bond.Master]
+		if !ok {
+			i = len(units)
+			byMaster[n.Bond.Master] = i
+			units = append(units, nil)
+		}
+		units[i] = append(units[i], n)
+	}
+
+	return units
+}
@@ -0,0 +1,41 @@
+package ringbuffer
+
+import (
+	"reflect"
+	"testing"
+
+	"optimize-hpc-nic/internal/nic"
+)
+
+func TestGroupByMaster(t *testing.T) {
+	slave := func(name, master string) *nic.NIC {
+		return &nic.NIC{Name: name, Bond: &nic.Membership{Master: master}}
+	}
+	nics := []*nic.NIC{
+		slave("eth0", "bond0"),
+		{Name: "eth1"},
+		slave("eth2", "bond1"),
+		slave("eth3", "bond0"),
+		{Name: "ib0"},
+		slave("eth4", "bond1"),
+	}
+
+	var got [][]string
+	for _, unit := range groupByMaster(nics) {
+		var names []string
+		for _, n := range unit {
+			names = append(names, n.Name)
+		}
+		got = append(got, names)
+	}
+
+	// Units keep the order of their first NIC, and slaves their own order
+	want := [][]string{{"eth0", "eth3"}, {"eth1"}, {"eth2", "eth4"}, {"ib0"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groupByMaster = %v, want %v", got, want)
+	}
+
+	if units := groupByMaster(nil); len(units) != 0 {
+		t.Errorf("groupByMaster(nil) = %v", units)
+	}
+}
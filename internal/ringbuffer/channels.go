@@ -0,0 +1,73 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+
+	"optimize-hpc-nic/internal/config"
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// channelTarget returns the channel counts required by the channel
+// policy, or false if the policy leaves the NIC alone
+func (o *Optimizer) channelTarget(n *nic.NIC) (system.Channels, bool) {
+	policy := o.cfg.Policy.Channels
+	max := n.ChannelsMax
+
+	switch policy.Policy {
+	case config.ChannelPolicyMax:
+		if max.Combined > 0 {
+			return system.Channels{Combined: max.Combined}, true
+		}
+		if max.RX > 0 || max.TX > 0 {
+			return system.Channels{RX: max.RX, TX: max.TX}, true
+		}
+	case config.ChannelPolicyNUMA:
+		if n.LocalCPUs > 0 && max.Combined > 0 {
+			return system.Channels{Combined: min(n.LocalCPUs, max.Combined)}, true
+		}
+		o.log.Debug("Cannot apply NUMA channel policy to %s (local CPUs: %d, max combined: %d)",
+			n.Name, n.LocalCPUs, max.Combined)
+	case config.ChannelPolicyCount:
+		if max.Combined > 0 {
+			return system.Channels{Combined: min(policy.Count, max.Combined)}, true
+		}
+	}
+
+	return system.Channels{}, false
+}
+
+// optimizeChannels applies the channel policy to a NIC
+func (o *Optimizer) optimizeChannels(ctx context.Context, n *nic.NIC) (bool, error) {
+	target, ok := o.channelTarget(n)
+	if !ok {
+		return false, nil
+	}
+
+	cur := n.Channels
+	if (target.Combined == 0 || target.Combined == cur.Combined) &&
+		(target.RX == 0 || target.RX == cur.RX) &&
+		(target.TX == 0 || target.TX == cur.TX) {
+		o.log.Debug("%s channels already match policy %s (combined: %d/%d)",
+			n.Name, o.cfg.Policy.Channels.Policy, cur.Combined, n.ChannelsMax.Combined)
+		return false, nil
+	}
+
+	if err := o.ethtool.SetChannels(ctx, n.Name, target); err != nil {
+		return false, fmt.Errorf("failed to set channels for %s: %v", n.Name, err)
+	}
+	o.log.Info("Set channels for %s to %+v (policy: %s)", n.Name, target, o.cfg.Policy.Channels.Policy)
+
+	if target.Combined > 0 {
+		n.Channels.Combined = target.Combined
+	}
+	if target.RX > 0 {
+		n.Channels.RX = target.RX
+	}
+	if target.TX > 0 {
+		n.Channels.TX = target.TX
+	}
+
+	return true, nil
+}
@@ -0,0 +1,80 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// coalesceChanges returns the coalesce parameters from the config file
+// that differ from the NIC's current settings, and a description of each
+// difference. Parameters the driver does not support are skipped.
+func (o *Optimizer) coalesceChanges(n *nic.NIC) (system.Coalesce, []string) {
+	change := system.Coalesce{Params: make(map[string]int)}
+	var diffs []string
+
+	target, ok := o.cfg.Policy.CoalesceFor(n.Name)
+	if !ok {
+		return change, nil
+	}
+
+	if target.AdaptiveRX != "" && n.Coalesce.AdaptiveRX != "" && target.AdaptiveRX != n.Coalesce.AdaptiveRX {
+		change.AdaptiveRX = target.AdaptiveRX
+		diffs = append(diffs, fmt.Sprintf("adaptive-rx %s->%s", n.Coalesce.AdaptiveRX, target.AdaptiveRX))
+	}
+	if target.AdaptiveTX != "" && n.Coalesce.AdaptiveTX != "" && target.AdaptiveTX != n.Coalesce.AdaptiveTX {
+		change.AdaptiveTX = target.AdaptiveTX
+		diffs = append(diffs, fmt.Sprintf("adaptive-tx %s->%s", n.Coalesce.AdaptiveTX, target.AdaptiveTX))
+	}
+
+	params := make([]string, 0, len(target.Params))
+	for param := range target.Params {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	for _, param := range params {
+		want := target.Params[param]
+		cur, supported := n.Coalesce.Params[param]
+		if !supported {
+			o.log.Debug("%s does not support coalesce parameter %s", n.Name, param)
+			continue
+		}
+		if cur != want {
+			change.Params[param] = want
+			diffs = append(diffs, fmt.Sprintf("%s %d->%d", param, cur, want))
+		}
+	}
+
+	return change, diffs
+}
+
+// optimizeCoalesce enforces the configured coalesce target on a NIC
+func (o *Optimizer) optimizeCoalesce(ctx context.Context, n *nic.NIC) (bool, error) {
+	change, diffs := o.coalesceChanges(n)
+	if len(diffs) == 0 {
+		return false, nil
+	}
+
+	if err := o.ethtool.SetCoalesce(ctx, n.Name, change); err != nil {
+		return false, fmt.Errorf("failed to set coalesce parameters for %s: %v", n.Name, err)
+	}
+	o.log.Info("Set coalesce parameters for %s: %s", n.Name, strings.Join(diffs, ", "))
+
+	// Update NIC object to reflect new settings
+	if change.AdaptiveRX != "" {
+		n.Coalesce.AdaptiveRX = change.AdaptiveRX
+	}
+	if change.AdaptiveTX != "" {
+		n.Coalesce.AdaptiveTX = change.AdaptiveTX
+	}
+	for param, value := range change.Params {
+		n.Coalesce.Params[param] = value
+	}
+
+	return true, nil
+}
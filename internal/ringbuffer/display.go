@@ -0,0 +1,380 @@
+package ringbuffer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/internal/stats"
+)
+
+// displayLinks prints the link state of every NIC and where its speed was
+// taken from, so down links classified by their supported link modes stand
+// out from slow ones
+func displayLinks(nics []*nic.NIC) {
+	fmt.Println("\n=== Link State ===")
+	fmt.Printf("%-15s %-16s %-8s %-12s %s\n", "Interface", "Operstate", "Carrier", "Speed(Mbps)", "Speed Source")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		carrier := "no"
+		if n.Carrier {
+			carrier = "yes"
+		}
+		fmt.Printf("%-15s %-16s %-8s %-12d %s\n",
+			n.Name, orNAString(n.OperState), carrier, n.Speed, orNAString(n.SpeedSource))
+	}
+}
+
+// displayPCI prints the SR-IOV function class, PCI location, NUMA locality
+// and PCIe link state of every NIC, flagging links that trained below their
+// maximum
+func displayPCI(nics []*nic.NIC) {
+	fmt.Println("\n=== PCI Topology ===")
+	fmt.Printf("%-15s %-8s %-14s %-11s %-6s %-16s %-20s %-20s %s\n",
+		"Interface", "Function", "PCI Address", "ID", "NUMA", "Local CPUs", "PCIe Link", "PCIe Max", "Status")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		if n.PCI.Address == "" {
+			fmt.Printf("%-15s %-8s %-14s\n", n.Name, orNAString(n.Function), "n/a")
+			continue
+		}
+		numa := "n/a"
+		if n.PCI.NUMANode >= 0 {
+			numa = fmt.Sprintf("%d", n.PCI.NUMANode)
+		}
+		status := "OK"
+		if n.PCI.Degraded() {
+			status = "DEGRADED"
+		}
+		fmt.Printf("%-15s %-8s %-14s %-11s %-6s %-16s %-20s %-20s %s\n",
+			n.Name, orNAString(n.Function), n.PCI.Address, orNAString(n.PCI.ID()), numa, orNAString(n.PCI.LocalCPUList),
+			pcieLink(n.PCI.LinkSpeed, n.PCI.LinkWidth), pcieLink(n.PCI.MaxLinkSpeed, n.PCI.MaxLinkWidth), status)
+	}
+}
+
+// displayRDMA prints the RDMA port behind every NIC that has one. It prints
+// nothing on hosts without RDMA devices.
+func displayRDMA(nics []*nic.NIC) {
+	printed := false
+	for _, n := range nics {
+		if n.RDMA == nil {
+			continue
+		}
+		if !printed {
+			fmt.Println("\n=== RDMA Ports ===")
+			fmt.Printf("%-15s %-11s %-11s %-8s %-10s %-21s %-7s %-20s %s\n",
+				"Interface", "Port", "Link Layer", "State", "Phys", "Rate", "LID", "Port GUID", "Firmware")
+			fmt.Println(strings.Repeat("-", 110))
+			printed = true
+		}
+		p := n.RDMA
+		fmt.Printf("%-15s %-11s %-11s %-8s %-10s %-21s %-7s %-20s %s\n",
+			n.Name, p.Name(), orNAString(p.LinkLayer), orNAString(p.State), orNAString(p.PhysState),
+			orNAString(p.Rate), orNAString(p.LID), orNAString(p.PortGUID), orNAString(p.FirmwareVersion))
+	}
+}
+
+// displayBonds prints the bond and team membership of every enslaved NIC,
+// grouped by master. It prints nothing when no NIC is enslaved.
+func displayBonds(nics []*nic.NIC) {
+	var slaves []*nic.NIC
+	for _, n := range nics {
+		if n.Bond != nil {
+			slaves = append(slaves, n)
+		}
+	}
+	if len(slaves) == 0 {
+		return
+	}
+	sort.SliceStable(slaves, func(i, j int) bool {
+		return slaves[i].Bond.Master < slaves[j].Bond.Master
+	})
+
+	fmt.Println("\n=== Bond/Team Membership ===")
+	fmt.Printf("%-15s %-6s %-15s %-15s %-10s %-10s\n", "Master", "Type", "Mode", "Slave", "Link", "State")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range slaves {
+		b := n.Bond
+		fmt.Printf("%-15s %-6s %-15s %-15s %-10s %-10s\n",
+			b.Master, b.Type, orNAString(b.Mode), n.Name, orNAString(b.MIIStatus), orNAString(b.State))
+	}
+}
+
+// pcieLink formats a PCIe link speed and width, e.g. "16.0 GT/s x16"
+func pcieLink(speed string, width int) string {
+	speed = strings.TrimSuffix(speed, " PCIe")
+	if speed == "" || width <= 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%s x%d", speed, width)
+}
+
+// displayIPoIB prints the send/receive rings and mode of every IPoIB
+// interface, with the ib_ipoib module defaults for comparison
+func displayIPoIB(nics []*nic.NIC) {
+	fmt.Println("\n=== IPoIB (current/max) ===")
+	fmt.Printf("%-15s %-12s %-12s %-12s %-18s %-18s\n",
+		"Interface", "Mode", "RX Ring", "TX Ring", "recv_queue_size", "send_queue_size")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		if n.LinkType != NICTypeInfiniband {
+			continue
+		}
+		fmt.Printf("%-15s %-12s %-12s %-12s %-18s %-18s\n",
+			n.Name, orNAString(n.IPoIB.Mode),
+			curMax(n.Ring.RX, n.RingMax.RX),
+			curMax(n.Ring.TX, n.RingMax.TX),
+			orNA(n.IPoIB.RecvQueueSize),
+			orNA(n.IPoIB.SendQueueSize))
+	}
+}
+
+// displayRingTargets prints the RX/TX sizes the ring rules require and the
+// rule that chose them
+func displayRingTargets(nics []*nic.NIC) {
+	fmt.Println("\n=== Ring Sizing (current/target) ===")
+	fmt.Printf("%-15s %-12s %-12s %s\n", "Interface", "RX", "TX", "Rule")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		rule := n.RingRule
+		if rule == "" {
+			rule = "default (max)"
+		}
+		if n.RingSkip != "" {
+			rule += ": " + n.RingSkip
+		}
+		fmt.Printf("%-15s %-12s %-12s %s\n",
+			n.Name,
+			curMax(n.Ring.RX, n.RingTarget.RX),
+			curMax(n.Ring.TX, n.RingTarget.TX),
+			rule)
+	}
+}
+
+// displayRingParams prints the full ring parameter set of every NIC
+func displayRingParams(nics []*nic.NIC) {
+	fmt.Println("\n=== Ring Parameters (current/max) ===")
+	fmt.Printf("%-15s %-12s %-12s %-12s %-12s %-11s %-9s %-8s %-8s %-10s\n",
+		"Interface", "RX", "RX Mini", "RX Jumbo", "TX", "RX Buf Len", "CQE Size", "TX Push", "RX Push", "TCP Split")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		if n.LinkType == NICTypeInfiniband {
+			continue
+		}
+		fmt.Printf("%-15s %-12s %-12s %-12s %-12s %-11s %-9s %-8s %-8s %-10s\n",
+			n.Name,
+			curMax(n.Ring.RX, n.RingMax.RX),
+			curMax(n.Ring.RXMini, n.RingMax.RXMini),
+			curMax(n.Ring.RXJumbo, n.RingMax.RXJumbo),
+			curMax(n.Ring.TX, n.RingMax.TX),
+			orNA(n.Ring.RXBufLen),
+			orNA(n.Ring.CQESize),
+			orNAString(n.Ring.TXPush),
+			orNAString(n.Ring.RXPush),
+			orNAString(n.Ring.TCPDataSplit))
+	}
+}
+
+// displayChannels prints the channel counts of every NIC
+func displayChannels(nics []*nic.NIC) {
+	fmt.Println("\n=== Channels (current/max) ===")
+	fmt.Printf("%-15s %-12s %-12s %-12s %-12s %-10s\n",
+		"Interface", "Combined", "RX", "TX", "Other", "Local CPUs")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		if n.LinkType == NICTypeInfiniband {
+			continue
+		}
+		fmt.Printf("%-15s %-12s %-12s %-12s %-12s %-10s\n",
+			n.Name,
+			curMax(n.Channels.Combined, n.ChannelsMax.Combined),
+			curMax(n.Channels.RX, n.ChannelsMax.RX),
+			curMax(n.Channels.TX, n.ChannelsMax.TX),
+			curMax(n.Channels.Other, n.ChannelsMax.Other),
+			orNA(n.LocalCPUs))
+	}
+}
+
+// displayCoalesce prints the interrupt coalescing settings of every NIC
+func displayCoalesce(nics []*nic.NIC) {
+	fmt.Println("\n=== Interrupt Coalescing ===")
+	fmt.Printf("%-15s %-14s %-10s %-10s %-10s %-10s\n",
+		"Interface", "Adaptive RX/TX", "rx-usecs", "rx-frames", "tx-usecs", "tx-frames")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		if n.LinkType == NICTypeInfiniband {
+			continue
+		}
+		fmt.Printf("%-15s %-14s %-10s %-10s %-10s %-10s\n",
+			n.Name,
+			fmt.Sprintf("%s/%s", orNAString(n.Coalesce.AdaptiveRX), orNAString(n.Coalesce.AdaptiveTX)),
+			coalesceParam(n, "rx-usecs"),
+			coalesceParam(n, "rx-frames"),
+			coalesceParam(n, "tx-usecs"),
+			coalesceParam(n, "tx-frames"))
+	}
+}
+
+// coalesceParam formats a coalesce parameter, or n/a if unsupported
+func coalesceParam(n *nic.NIC, param string) string {
+	if v, ok := n.Coalesce.Params[param]; ok {
+		return fmt.Sprintf("%d", v)
+	}
+	return "n/a"
+}
+
+// displayOffloads prints key offload features and policy mismatches
+func displayOffloads(nics []*nic.NIC) {
+	fmt.Println("\n=== Offload Features ===")
+	fmt.Printf("%-15s %-5s %-5s %-8s %-8s %s\n",
+		"Interface", "GRO", "LRO", "RX-CSUM", "HW-TC", "Policy Mismatches")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		if n.LinkType == NICTypeInfiniband {
+			continue
+		}
+		mismatches := "none"
+		if len(n.OffloadMismatches) > 0 {
+			mismatches = strings.Join(n.OffloadMismatches, ", ")
+		}
+		fmt.Printf("%-15s %-5s %-5s %-8s %-8s %s\n",
+			n.Name,
+			featureState(n, "generic-receive-offload"),
+			featureState(n, "large-receive-offload"),
+			featureState(n, "rx-checksumming"),
+			featureState(n, "hw-tc-offload"),
+			mismatches)
+	}
+	fmt.Println("(* = fixed, cannot be changed)")
+}
+
+// featureState formats an offload feature, marking fixed features
+func featureState(n *nic.NIC, name string) string {
+	f, ok := n.Features[name]
+	if !ok {
+		return "n/a"
+	}
+	if f.Fixed {
+		return onOff(f.Enabled) + "*"
+	}
+	return onOff(f.Enabled)
+}
+
+// displayPause prints the pause frame settings of every NIC
+func displayPause(nics []*nic.NIC) {
+	fmt.Println("\n=== Pause Frames ===")
+	fmt.Printf("%-15s %-10s %-5s %-5s\n", "Interface", "Autoneg", "RX", "TX")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		if n.LinkType == NICTypeInfiniband {
+			continue
+		}
+		fmt.Printf("%-15s %-10s %-5s %-5s\n",
+			n.Name, orNAString(n.Pause.Autoneg), orNAString(n.Pause.RX), orNAString(n.Pause.TX))
+	}
+}
+
+// displayPrivFlags prints driver private flags and policy mismatches
+func displayPrivFlags(nics []*nic.NIC) {
+	fmt.Println("\n=== Private Flags ===")
+	fmt.Printf("%-15s %-15s %s\n", "Interface", "Driver", "Flags / Policy Mismatches")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		if n.LinkType == NICTypeInfiniband || len(n.PrivFlags) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(n.PrivFlags))
+		for name := range n.PrivFlags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		flags := make([]string, 0, len(names))
+		for _, name := range names {
+			flags = append(flags, fmt.Sprintf("%s=%s", name, onOff(n.PrivFlags[name])))
+		}
+		fmt.Printf("%-15s %-15s %s\n", n.Name, n.Driver, strings.Join(flags, " "))
+
+		if len(n.PrivFlagMismatches) > 0 {
+			fmt.Printf("%-15s %-15s mismatches: %s\n", "", "", strings.Join(n.PrivFlagMismatches, ", "))
+		}
+	}
+}
+
+// displayFEC prints the configured and active FEC encodings of every
+// Ethernet NIC
+func displayFEC(nics []*nic.NIC) {
+	fmt.Println("\n=== Forward Error Correction ===")
+	fmt.Printf("%-15s %-12s %-25s %-12s %-15s\n", "Interface", "Speed(Mbps)", "Configured", "Active", "Required")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		if n.LinkType == NICTypeInfiniband {
+			continue
+		}
+		required := "-"
+		if n.FECMismatch != "" {
+			required = n.FECMismatch
+		}
+		fmt.Printf("%-15s %-12d %-25s %-12s %-15s\n",
+			n.Name, n.Speed, orNAString(strings.Join(n.FEC.Configured, " ")),
+			orNAString(n.FEC.Active), required)
+	}
+}
+
+// displayStats prints the non-zero drop and error counters of every NIC
+func displayStats(nics []*nic.NIC, counters map[string][]stats.Delta) {
+	fmt.Println("\n=== Drop/Error Counters ===")
+	fmt.Printf("%-15s %-8s %-30s %-15s %-12s %-10s\n",
+		"Interface", "Source", "Counter", "Value", "Delta", "Rate/s")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		printed := false
+		for _, d := range counters[n.Name] {
+			if d.Value == 0 {
+				continue
+			}
+			fmt.Printf("%-15s %-8s %-30s %-15d %-12d %-10.1f\n",
+				n.Name, d.Source, d.Name, d.Value, d.Delta, d.Rate)
+			printed = true
+		}
+		if !printed {
+			fmt.Printf("%-15s %-8s %-30s\n", n.Name, "-", "none")
+		}
+	}
+}
+
+// curMax formats a current/max pair, or n/a if the driver reports neither
+func curMax(cur, max int) string {
+	if cur <= 0 && max <= 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%d/%d", cur, max)
+}
+
+func orNA(v int) string {
+	if v <= 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%d", v)
+}
+
+func orNAString(v string) string {
+	if v == "" {
+		return "n/a"
+	}
+	return v
+}
@@ -0,0 +1,54 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// fecChange returns the FEC encoding required for the NIC's speed when it is
+// not configured, or "" when nothing needs to change. A required "auto" is
+// satisfied when auto is among the configured encodings; any other encoding
+// when it is the only one. The active encoding is not compared: it is None
+// while the link is down and may be whatever the link partner negotiated,
+// and setting FEC again would only drop the link once more.
+func (o *Optimizer) fecChange(n *nic.NIC) string {
+	want, ok := o.cfg.Policy.FEC[n.Speed]
+	if !ok || len(n.FEC.Configured) == 0 {
+		return ""
+	}
+
+	if strings.EqualFold(want, "auto") {
+		for _, mode := range n.FEC.Configured {
+			if strings.EqualFold(mode, "auto") {
+				return ""
+			}
+		}
+	} else if len(n.FEC.Configured) == 1 && strings.EqualFold(n.FEC.Configured[0], want) {
+		return ""
+	}
+
+	return strings.ToLower(want)
+}
+
+// optimizeFEC applies the FEC encoding required for the NIC's speed class
+func (o *Optimizer) optimizeFEC(ctx context.Context, n *nic.NIC) (bool, error) {
+	encoding := o.fecChange(n)
+	n.FECMismatch = encoding
+	if encoding == "" {
+		return false, nil
+	}
+
+	if err := o.ethtool.SetFEC(ctx, n.Name, encoding); err != nil {
+		return false, fmt.Errorf("failed to set FEC for %s: %v", n.Name, err)
+	}
+	o.log.Info("Set FEC encoding for %s (%dMbps) to %s (was %s)", n.Name, n.Speed, encoding, strings.Join(n.FEC.Configured, " "))
+
+	// Update NIC object to reflect new settings
+	n.FEC.Configured = system.FECEncodings(encoding)
+	n.FECMismatch = ""
+	return true, nil
+}
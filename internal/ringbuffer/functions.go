@@ -0,0 +1,19 @@
+package ringbuffer
+
+import (
+	"fmt"
+
+	"optimize-hpc-nic/internal/nic"
+)
+
+// skipReason returns why a NIC is left alone by the optimizer, or "" if it
+// is optimized. Reasons set during discovery are kept.
+func (o *Optimizer) skipReason(n *nic.NIC) string {
+	if n.SkipReason != "" {
+		return n.SkipReason
+	}
+	if n.Function != "" && !o.cfg.Policy.OptimizesFunction(n.Function) {
+		return fmt.Sprintf("%s function class is not selected by the functions policy", n.Function)
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+package ringbuffer
+
+import (
+	"testing"
+
+	"optimize-hpc-nic/internal/config"
+	"optimize-hpc-nic/internal/nic"
+)
+
+func TestSkipReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		functions []string
+		nic       nic.NIC
+		want      string
+	}{
+		{"pf by default", nil, nic.NIC{Function: nic.FunctionPF}, ""},
+		{"uplink by default", nil, nic.NIC{Function: nic.FunctionUplink}, ""},
+		{"vf by default", nil, nic.NIC{Function: nic.FunctionVF}, "vf function class is not selected by the functions policy"},
+		{"vf-rep by default", nil, nic.NIC{Function: nic.FunctionVFRep}, "vf-rep function class is not selected by the functions policy"},
+		{"vf selected", []string{config.FunctionVF}, nic.NIC{Function: nic.FunctionVF}, ""},
+		{"pf not selected", []string{config.FunctionVF}, nic.NIC{Function: nic.FunctionPF}, "pf function class is not selected by the functions policy"},
+		{"unclassified", nil, nic.NIC{}, ""},
+		{"discovery reason kept", nil, nic.NIC{Function: nic.FunctionVF, SkipReason: "excluded by exclude[0]"}, "excluded by exclude[0]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &Optimizer{cfg: &config.Config{Policy: config.Policy{Functions: tt.functions}}}
+			if got := o.skipReason(&tt.nic); got != tt.want {
+				t.Errorf("skipReason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"optimize-hpc-nic/pkg/system"
+)
+
+// Inventory prints the driver, firmware and board identity of every
+// high-speed NIC, one line per NIC prefixed with the host name so the
+// output of many nodes can be concatenated and compared
+func (o *Optimizer) Inventory(ctx context.Context) error {
+	nics, err := o.nicMgr.GetHighSpeedNICs(ctx)
+	if err != nil {
+		o.log.Error("Error querying NICs: %v", err)
+		return err
+	}
+
+	host, _ := os.Hostname()
+
+	fmt.Printf("%-20s %-12s %-12s %-18s %-26s %-10s %-14s %-20s %-16s %s\n",
+		"Host", "Interface", "Driver", "Driver Version", "Firmware", "Exp ROM", "Bus Info", "Part Number", "Serial", "Board")
+	fmt.Println(strings.Repeat("-", 110))
+
+	for _, n := range nics {
+		// VPD reads can hang on the same broken device as ethtool, so
+		// they are bounded by -timeout and print n/a when they expire
+		var vpd system.VPD
+		if !n.Unresponsive {
+			vpd, err = o.nicMgr.GetVPD(ctx, n.Name)
+			if err != nil {
+				o.log.Debug("Failed to read VPD of %s: %v", n.Name, err)
+			}
+		}
+		d := n.DriverInfo
+		fmt.Printf("%-20s %-12s %-12s %-18s %-26s %-10s %-14s %-20s %-16s %s\n",
+			host, n.Name, orNAString(d.Driver), orNAString(d.Version), orNAString(d.FirmwareVersion),
+			orNAString(d.ExpansionROMVersion), orNAString(d.BusInfo),
+			orNAString(vpd.PartNumber()), orNAString(vpd.SerialNumber()), orNAString(vpd.ProductName))
+	}
+
+	if len(nics) == 0 {
+		fmt.Println("No high-speed NICs found.")
+	}
+	return nil
+}
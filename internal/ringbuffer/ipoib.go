@@ -0,0 +1,27 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+
+	"optimize-hpc-nic/internal/nic"
+)
+
+// optimizeIPoIBMode switches an IPoIB interface to the mode from the config
+// file
+func (o *Optimizer) optimizeIPoIBMode(ctx context.Context, n *nic.NIC) (bool, error) {
+	want := o.cfg.Policy.IPoIB.Mode
+	if want == "" || n.IPoIB.Mode == "" || n.IPoIB.Mode == want {
+		return false, nil
+	}
+
+	if err := o.nicMgr.SetIPoIBMode(n.Name, want); err != nil {
+		return false, fmt.Errorf("failed to set IPoIB mode for %s: %v", n.Name, err)
+	}
+	o.log.Info("Set IPoIB mode for %s to %s (was %s)", n.Name, want, n.IPoIB.Mode)
+
+	// Update NIC object to reflect new settings
+	n.IPoIB.Mode = want
+
+	return true, nil
+}
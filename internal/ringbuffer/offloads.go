@@ -0,0 +1,86 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// offloadChanges compares a NIC's offload features with the policy. It
+// returns the features that can be corrected and a description of every
+// mismatch, including [fixed] features that cannot be changed.
+func (o *Optimizer) offloadChanges(n *nic.NIC) (map[string]bool, []string) {
+	changes := make(map[string]bool)
+	var mismatches []string
+
+	desired, ok := o.cfg.Policy.OffloadsFor(n.Name)
+	if !ok {
+		return changes, nil
+	}
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		want := desired[name]
+		feature := system.FeatureName(name)
+		cur, supported := n.Features[feature]
+		if !supported {
+			o.log.Debug("%s does not report offload feature %s", n.Name, feature)
+			continue
+		}
+		if cur.Enabled == want {
+			continue
+		}
+
+		if cur.Fixed {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s (want %s, fixed)", feature, onOff(cur.Enabled), onOff(want)))
+			continue
+		}
+		changes[feature] = want
+		mismatches = append(mismatches, fmt.Sprintf("%s: %s (want %s)", feature, onOff(cur.Enabled), onOff(want)))
+	}
+
+	return changes, mismatches
+}
+
+// optimizeOffloads corrects offload features that differ from the policy
+func (o *Optimizer) optimizeOffloads(ctx context.Context, n *nic.NIC) (bool, error) {
+	changes, mismatches := o.offloadChanges(n)
+	n.OffloadMismatches = mismatches
+
+	if len(changes) > 0 {
+		if err := o.ethtool.SetFeatures(ctx, n.Name, changes); err != nil {
+			return false, fmt.Errorf("failed to set offload features for %s: %v", n.Name, err)
+		}
+		o.log.Info("Corrected offload features for %s: %v", n.Name, changes)
+
+		// Update NIC object to reflect new settings
+		for feature, enabled := range changes {
+			f := n.Features[feature]
+			f.Enabled = enabled
+			n.Features[feature] = f
+		}
+		_, n.OffloadMismatches = o.offloadChanges(n)
+	}
+
+	// Only fixed features can still differ
+	for _, mismatch := range n.OffloadMismatches {
+		o.log.Info("Cannot correct offload feature on %s: %s", n.Name, mismatch)
+	}
+
+	return len(changes) > 0, nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
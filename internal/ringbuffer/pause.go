@@ -0,0 +1,72 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// pauseChanges returns the pause settings from the policy that differ
+// from the NIC's current settings, and a description of each difference
+func (o *Optimizer) pauseChanges(n *nic.NIC) (system.PauseParams, []string) {
+	var change system.PauseParams
+	var diffs []string
+
+	target, ok := o.cfg.Policy.PauseFor(n.Name)
+	if !ok {
+		return change, nil
+	}
+
+	for _, f := range []struct {
+		name string
+		want *bool
+		cur  string
+		dst  *string
+	}{
+		{"autoneg", target.Autoneg, n.Pause.Autoneg, &change.Autoneg},
+		{"rx", target.RX, n.Pause.RX, &change.RX},
+		{"tx", target.TX, n.Pause.TX, &change.TX},
+	} {
+		if f.want == nil || f.cur == "" || f.cur == onOff(*f.want) {
+			continue
+		}
+		*f.dst = onOff(*f.want)
+		diffs = append(diffs, fmt.Sprintf("%s %s->%s", f.name, f.cur, *f.dst))
+	}
+
+	return change, diffs
+}
+
+// optimizePause enforces the configured pause frame settings on a NIC
+func (o *Optimizer) optimizePause(ctx context.Context, n *nic.NIC) (bool, error) {
+	change, diffs := o.pauseChanges(n)
+	if len(diffs) == 0 {
+		return false, nil
+	}
+
+	if err := o.ethtool.SetPause(ctx, n.Name, change); err != nil {
+		return false, fmt.Errorf("failed to set pause parameters for %s: %v", n.Name, err)
+	}
+	o.log.Info("Set pause parameters for %s: %s", n.Name, strings.Join(diffs, ", "))
+
+	// Update NIC object to reflect new settings
+	n.Pause = system.PauseParams{
+		Autoneg: firstNonEmpty(change.Autoneg, n.Pause.Autoneg),
+		RX:      firstNonEmpty(change.RX, n.Pause.RX),
+		TX:      firstNonEmpty(change.TX, n.Pause.TX),
+	}
+
+	return true, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,269 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"optimize-hpc-nic/internal/nic"
+)
+
+// Change is a setting that set mode would change on a NIC
+type Change struct {
+	Setting string
+	Current string
+	Planned string
+}
+
+// Plan runs discovery and the policy like set mode and prints the changes
+// set mode would make, without calling any setter. It returns the number of
+// NICs with pending changes, and an error when a NIC could not be evaluated
+// so a clean plan is never reported for NICs that were not checked.
+func (o *Optimizer) Plan(ctx context.Context) (int, error) {
+	nics, err := o.nicMgr.GetHighSpeedNICs(ctx)
+	if err != nil {
+		o.log.Error("Error getting NICs: %v", err)
+		return 0, err
+	}
+
+	fmt.Println("\n=== Plan: Pending Changes for High-Speed NICs (≥200G) ===")
+	fmt.Printf("%-15s %-28s %-28s %s\n", "Interface", "Setting", "Current", "Planned")
+	fmt.Println(strings.Repeat("-", 110))
+
+	pending := 0
+	total := 0
+	unevaluated := make(map[string]string)
+	for _, n := range nics {
+		if n.SkipReason = o.skipReason(n); n.SkipReason != "" {
+			if n.Unresponsive {
+				unevaluated[n.Name] = n.SkipReason
+			}
+			continue
+		}
+		// Without the pre-set maximums the ring targets are unknown
+		if n.RingMax.RX <= 0 || n.RingMax.TX <= 0 {
+			unevaluated[n.Name] = "its ring settings could not be read"
+			fmt.Printf("%-15s %s\n", n.Name, "(could not be evaluated)")
+			continue
+		}
+		changes := o.PlanNIC(n)
+		if len(changes) == 0 {
+			fmt.Printf("%-15s %s\n", n.Name, "(up to date)")
+			continue
+		}
+		pending++
+		total += len(changes)
+		for _, c := range changes {
+			fmt.Printf("%-15s %-28s %-28s %s\n", n.Name, c.Setting, c.Current, c.Planned)
+		}
+	}
+
+	if len(nics) == 0 {
+		fmt.Println("No high-speed NICs found.")
+		return 0, nil
+	}
+
+	fmt.Println(strings.Repeat("-", 110))
+	fmt.Printf("PLAN: %d changes pending on %d of %d NICs\n", total, pending, len(nics))
+	for _, n := range nics {
+		if n.Unresponsive {
+			fmt.Printf("NOTE: %s is unresponsive: %s\n", n.Name, n.SkipReason)
+		} else if n.SkipReason != "" {
+			fmt.Printf("NOTE: %s is skipped: %s\n", n.Name, n.SkipReason)
+		} else if reason, ok := unevaluated[n.Name]; ok {
+			fmt.Printf("NOTE: %s could not be evaluated: %s\n", n.Name, reason)
+		} else if n.RingSkip != "" {
+			fmt.Printf("NOTE: %s %s\n", n.Name, n.RingSkip)
+		}
+	}
+
+	if len(unevaluated) > 0 {
+		return pending, fmt.Errorf("%d of %d NICs could not be evaluated", len(unevaluated), len(nics))
+	}
+	return pending, nil
+}
+
+// PlanNIC returns the changes OptimizeNIC would make to a NIC, in the order
+// it makes them
+func (o *Optimizer) PlanNIC(n *nic.NIC) []Change {
+	planners := []func(*nic.NIC) []Change{
+		o.planRings,
+		o.planChannels,
+		o.planCoalesce,
+		o.planOffloads,
+		o.planPause,
+		o.planPrivFlags,
+		o.planFEC,
+	}
+	// IPoIB interfaces only have send/receive rings and the IPoIB mode
+	if n.LinkType == NICTypeInfiniband {
+		planners = []func(*nic.NIC) []Change{
+			o.planRings,
+			o.planIPoIBMode,
+		}
+	}
+
+	var changes []Change
+	for _, planner := range planners {
+		changes = append(changes, planner(n)...)
+	}
+	return changes
+}
+
+// planRings mirrors optimizeRings
+func (o *Optimizer) planRings(n *nic.NIC) []Change {
+	var changes []Change
+
+	if n.RingMax.RX > 0 && n.RingMax.TX > 0 {
+		rule := "(max)"
+		if n.RingRule != "" {
+			rule = "by " + n.RingRule
+		}
+		if n.Ring.RX != n.RingTarget.RX {
+			changes = append(changes, Change{"ring rx", strconv.Itoa(n.Ring.RX), fmt.Sprintf("%d %s", n.RingTarget.RX, rule)})
+		}
+		if n.Ring.TX != n.RingTarget.TX {
+			changes = append(changes, Change{"ring tx", strconv.Itoa(n.Ring.TX), fmt.Sprintf("%d %s", n.RingTarget.TX, rule)})
+		}
+	}
+
+	extra := o.ringPolicyChanges(n)
+	for _, f := range []struct {
+		name      string
+		cur, want int
+	}{
+		{"ring rx-mini", n.Ring.RXMini, extra.RXMini},
+		{"ring rx-jumbo", n.Ring.RXJumbo, extra.RXJumbo},
+		{"ring rx-buf-len", n.Ring.RXBufLen, extra.RXBufLen},
+		{"ring cqe-size", n.Ring.CQESize, extra.CQESize},
+	} {
+		if f.want > 0 {
+			changes = append(changes, Change{f.name, strconv.Itoa(f.cur), strconv.Itoa(f.want)})
+		}
+	}
+	for _, f := range []struct{ name, cur, want string }{
+		{"ring tx-push", n.Ring.TXPush, extra.TXPush},
+		{"ring rx-push", n.Ring.RXPush, extra.RXPush},
+		{"ring tcp-data-split", n.Ring.TCPDataSplit, extra.TCPDataSplit},
+	} {
+		if f.want != "" {
+			changes = append(changes, Change{f.name, f.cur, f.want})
+		}
+	}
+
+	return changes
+}
+
+// planChannels mirrors optimizeChannels
+func (o *Optimizer) planChannels(n *nic.NIC) []Change {
+	target, ok := o.channelTarget(n)
+	if !ok {
+		return nil
+	}
+
+	var changes []Change
+	for _, f := range []struct {
+		name      string
+		cur, want int
+	}{
+		{"channels combined", n.Channels.Combined, target.Combined},
+		{"channels rx", n.Channels.RX, target.RX},
+		{"channels tx", n.Channels.TX, target.TX},
+	} {
+		if f.want > 0 && f.want != f.cur {
+			changes = append(changes, Change{f.name, strconv.Itoa(f.cur), fmt.Sprintf("%d (%s)", f.want, o.cfg.Policy.Channels.Policy)})
+		}
+	}
+	return changes
+}
+
+// planCoalesce mirrors optimizeCoalesce
+func (o *Optimizer) planCoalesce(n *nic.NIC) []Change {
+	change, _ := o.coalesceChanges(n)
+
+	var changes []Change
+	if change.AdaptiveRX != "" {
+		changes = append(changes, Change{"coalesce adaptive-rx", n.Coalesce.AdaptiveRX, change.AdaptiveRX})
+	}
+	if change.AdaptiveTX != "" {
+		changes = append(changes, Change{"coalesce adaptive-tx", n.Coalesce.AdaptiveTX, change.AdaptiveTX})
+	}
+	for _, param := range sortedKeys(change.Params) {
+		changes = append(changes, Change{"coalesce " + param,
+			strconv.Itoa(n.Coalesce.Params[param]), strconv.Itoa(change.Params[param])})
+	}
+	return changes
+}
+
+// planOffloads mirrors optimizeOffloads. Fixed features are not listed as
+// they cannot be changed.
+func (o *Optimizer) planOffloads(n *nic.NIC) []Change {
+	desired, _ := o.offloadChanges(n)
+
+	var changes []Change
+	for _, feature := range sortedKeys(desired) {
+		changes = append(changes, Change{"offload " + feature,
+			onOff(n.Features[feature].Enabled), onOff(desired[feature])})
+	}
+	return changes
+}
+
+// planPause mirrors optimizePause
+func (o *Optimizer) planPause(n *nic.NIC) []Change {
+	change, _ := o.pauseChanges(n)
+
+	var changes []Change
+	for _, f := range []struct{ name, cur, want string }{
+		{"pause autoneg", n.Pause.Autoneg, change.Autoneg},
+		{"pause rx", n.Pause.RX, change.RX},
+		{"pause tx", n.Pause.TX, change.TX},
+	} {
+		if f.want != "" {
+			changes = append(changes, Change{f.name, f.cur, f.want})
+		}
+	}
+	return changes
+}
+
+// planPrivFlags mirrors optimizePrivFlags
+func (o *Optimizer) planPrivFlags(n *nic.NIC) []Change {
+	desired, _ := o.privFlagChanges(n)
+
+	var changes []Change
+	for _, name := range sortedKeys(desired) {
+		changes = append(changes, Change{"priv-flag " + name,
+			onOff(n.PrivFlags[name]), onOff(desired[name])})
+	}
+	return changes
+}
+
+// planFEC mirrors optimizeFEC
+func (o *Optimizer) planFEC(n *nic.NIC) []Change {
+	encoding := o.fecChange(n)
+	if encoding == "" {
+		return nil
+	}
+	current := fmt.Sprintf("%s (configured %s)", orNAString(n.FEC.Active), strings.Join(n.FEC.Configured, " "))
+	return []Change{{"fec", current, encoding}}
+}
+
+// planIPoIBMode mirrors optimizeIPoIBMode
+func (o *Optimizer) planIPoIBMode(n *nic.NIC) []Change {
+	want := o.cfg.Policy.IPoIB.Mode
+	if want == "" || n.IPoIB.Mode == "" || n.IPoIB.Mode == want {
+		return nil
+	}
+	return []Change{{"ipoib mode", n.IPoIB.Mode, want}}
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
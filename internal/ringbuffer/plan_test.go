@@ -0,0 +1,138 @@
+package ringbuffer
+
+import (
+	"reflect"
+	"testing"
+
+	"optimize-hpc-nic/internal/config"
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/pkg/system"
+)
+
+func TestPlanNIC(t *testing.T) {
+	on := true
+	policy := config.Policy{
+		Channels:  config.ChannelPolicy{Policy: config.ChannelPolicyMax},
+		Coalesce:  map[string]config.CoalesceTarget{"eth*": {AdaptiveRX: "off", Params: map[string]int{"rx-usecs": 32}}},
+		Offloads:  map[string]map[string]bool{"eth*": {"gro": true, "tx-checksum-ipv4": true}},
+		Pause:     map[string]config.PauseTarget{"eth*": {RX: &on}},
+		PrivFlags: map[string]map[string]bool{"mlx5_core": {"rx_cqe_compress": true, "missing_flag": true}},
+		FEC:       map[int]string{400000: "rs"},
+		IPoIB:     config.IPoIBPolicy{Mode: config.IPoIBModeConnected},
+	}
+
+	// ethernet returns an mlx5 NIC differing from the policy in every setting
+	ethernet := func() *nic.NIC {
+		return &nic.NIC{
+			Name:        "eth0",
+			Speed:       400000,
+			Driver:      "mlx5_core",
+			LinkType:    NICTypeEthernet,
+			Ring:        system.RingParams{RX: 1024, TX: 1024},
+			RingMax:     system.RingParams{RX: 8192, TX: 8192},
+			RingTarget:  system.RingParams{RX: 8192, TX: 8192},
+			Channels:    system.Channels{Combined: 8},
+			ChannelsMax: system.Channels{Combined: 63},
+			Coalesce:    system.Coalesce{AdaptiveRX: "on", AdaptiveTX: "on", Params: map[string]int{"rx-usecs": 8}},
+			Features: map[string]system.Feature{
+				"generic-receive-offload": {Enabled: false},
+				"tx-checksum-ipv4":        {Enabled: false, Fixed: true},
+			},
+			Pause:     system.PauseParams{Autoneg: "on", RX: "off", TX: "on"},
+			PrivFlags: map[string]bool{"rx_cqe_compress": false},
+			FEC:       system.FECParams{Configured: []string{"Auto", "RS"}, Active: "BaseR"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy config.Policy
+		nic    func() *nic.NIC
+		want   []Change
+	}{
+		{
+			name:   "every setting differs",
+			policy: policy,
+			nic:    ethernet,
+			want: []Change{
+				{"ring rx", "1024", "8192 (max)"},
+				{"ring tx", "1024", "8192 (max)"},
+				{"channels combined", "8", "63 (max)"},
+				{"coalesce adaptive-rx", "on", "off"},
+				{"coalesce rx-usecs", "8", "32"},
+				{"offload generic-receive-offload", "off", "on"},
+				{"pause rx", "off", "on"},
+				{"priv-flag rx_cqe_compress", "off", "on"},
+				{"fec", "BaseR (configured Auto RS)", "rs"},
+			},
+		},
+		{
+			name:   "up to date",
+			policy: config.Policy{},
+			nic: func() *nic.NIC {
+				n := ethernet()
+				n.Ring = n.RingTarget
+				return n
+			},
+			want: nil,
+		},
+		{
+			name:   "ring rule and ring parameters",
+			policy: config.Policy{Ring: config.RingPolicy{CQESize: 128, TXPush: "on"}},
+			nic: func() *nic.NIC {
+				n := ethernet()
+				n.Ring = system.RingParams{RX: 4096, TX: 1024, CQESize: 64, TXPush: "off"}
+				n.RingTarget = system.RingParams{RX: 4096, TX: 4096}
+				n.RingRule = "ring.rules[0]"
+				return n
+			},
+			want: []Change{
+				{"ring tx", "1024", "4096 by ring.rules[0]"},
+				{"ring cqe-size", "64", "128"},
+				{"ring tx-push", "off", "on"},
+			},
+		},
+		{
+			// Without the pre-set maximums the ring targets are unknown
+			name:   "rings not read",
+			policy: config.Policy{},
+			nic: func() *nic.NIC {
+				n := ethernet()
+				n.RingMax = system.RingParams{}
+				return n
+			},
+			want: nil,
+		},
+		{
+			// Only the rings and the mode of IPoIB interfaces are planned
+			name:   "infiniband",
+			policy: policy,
+			nic: func() *nic.NIC {
+				n := ethernet()
+				n.Name = "ib0"
+				n.LinkType = NICTypeInfiniband
+				n.IPoIB = nic.IPoIBInfo{Mode: config.IPoIBModeDatagram}
+				return n
+			},
+			want: []Change{
+				{"ring rx", "1024", "8192 (max)"},
+				{"ring tx", "1024", "8192 (max)"},
+				{"ipoib mode", "datagram", "connected"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newTestOptimizer(t, &config.Config{Policy: tt.policy}, t.TempDir())
+			n := tt.nic()
+			got := o.PlanNIC(n)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanNIC =\n%v\nwant\n%v", got, tt.want)
+			}
+			if pending := o.hasPendingChanges(n); pending != (len(tt.want) > 0) {
+				t.Errorf("hasPendingChanges = %v with %d changes", pending, len(tt.want))
+			}
+		})
+	}
+}
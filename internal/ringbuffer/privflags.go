@@ -0,0 +1,64 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"optimize-hpc-nic/internal/nic"
+)
+
+// privFlagChanges returns the private flags declared for the NIC's driver
+// that differ from the current values, and a description of each
+func (o *Optimizer) privFlagChanges(n *nic.NIC) (map[string]bool, []string) {
+	changes := make(map[string]bool)
+	var mismatches []string
+
+	desired, ok := o.cfg.Policy.PrivFlagsFor(n.Driver)
+	if !ok {
+		return changes, nil
+	}
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		want := desired[name]
+		cur, supported := n.PrivFlags[name]
+		if !supported {
+			o.log.Debug("%s (%s) does not have private flag %s", n.Name, n.Driver, name)
+			continue
+		}
+		if cur != want {
+			changes[name] = want
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s (want %s)", name, onOff(cur), onOff(want)))
+		}
+	}
+
+	return changes, mismatches
+}
+
+// optimizePrivFlags applies the private flags declared for the NIC's driver
+func (o *Optimizer) optimizePrivFlags(ctx context.Context, n *nic.NIC) (bool, error) {
+	changes, mismatches := o.privFlagChanges(n)
+	n.PrivFlagMismatches = mismatches
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	if err := o.ethtool.SetPrivFlags(ctx, n.Name, changes); err != nil {
+		return false, fmt.Errorf("failed to set private flags for %s: %v", n.Name, err)
+	}
+	o.log.Info("Set private flags for %s (%s): %v", n.Name, n.Driver, changes)
+
+	// Update NIC object to reflect new settings
+	for name, enabled := range changes {
+		n.PrivFlags[name] = enabled
+	}
+	n.PrivFlagMismatches = nil
+
+	return true, nil
+}
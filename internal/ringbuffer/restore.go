@@ -0,0 +1,384 @@
+package ringbuffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/internal/state"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// stateStore loads the state file on first use
+func (o *Optimizer) stateStore() (*state.Store, error) {
+	o.stateOnce.Do(func() {
+		o.state, o.stateErr = state.Load(o.cfg.StateFile)
+	})
+	return o.state, o.stateErr
+}
+
+// stateKey identifies a NIC in the state file. The 20-byte hardware
+// address of an IPoIB interface starts with its QPN, which changes when the
+// driver reloads, so only its last 8 bytes, the port GUID, are used.
+func stateKey(n *nic.NIC) string {
+	addr := n.MAC
+	if n.LinkType == NICTypeInfiniband {
+		if octets := strings.Split(addr, ":"); len(octets) > 8 {
+			addr = strings.Join(octets[len(octets)-8:], ":")
+		}
+	}
+	return state.Key(n.PCI.Address, addr)
+}
+
+// rememberOriginal records the settings of a NIC before its first change
+func (o *Optimizer) rememberOriginal(n *nic.NIC) error {
+	if o.cfg.StateFile == "" {
+		return nil
+	}
+	store, err := o.stateStore()
+	if err != nil {
+		return err
+	}
+
+	// The optimizer updates the NIC's maps in place as it changes them
+	coalesce := n.Coalesce
+	coalesce.Params = maps.Clone(n.Coalesce.Params)
+
+	return store.Remember(stateKey(n), state.Entry{
+		Name:      n.Name,
+		Driver:    n.Driver,
+		PCI:       n.PCI.Address,
+		MAC:       n.MAC,
+		Recorded:  time.Now(),
+		Ring:      n.Ring,
+		Channels:  n.Channels,
+		Coalesce:  coalesce,
+		Features:  maps.Clone(n.Features),
+		Pause:     n.Pause,
+		PrivFlags: maps.Clone(n.PrivFlags),
+		FEC:       slices.Clone(n.FEC.Configured),
+		IPoIBMode: n.IPoIB.Mode,
+	})
+}
+
+// Restore puts every NIC recorded in the state file back to its original
+// settings and drops the entries of the NICs restored
+func (o *Optimizer) Restore(ctx context.Context) error {
+	if o.cfg.StateFile == "" {
+		err := errors.New("no state file: original settings are not recorded with -replay or -root unless -state is given")
+		o.log.Error("Error loading state: %v", err)
+		return err
+	}
+	store, err := o.stateStore()
+	if err != nil {
+		o.log.Error("Error loading state: %v", err)
+		return err
+	}
+	entries := store.Entries()
+	if len(entries) == 0 {
+		fmt.Printf("No original settings recorded in %s\n", store.Path())
+		return nil
+	}
+
+	// Recorded NICs may have slowed down or been deselected since
+	nics, err := o.nicMgr.GetAllNICs(ctx)
+	if err != nil {
+		o.log.Error("Error getting NICs: %v", err)
+		return err
+	}
+
+	var targets []*nic.NIC
+	for _, n := range nics {
+		if _, ok := entries[stateKey(n)]; ok && !n.Unresponsive {
+			targets = append(targets, n)
+		}
+	}
+
+	// Slaves of the same bond or team are restored one at a time
+	results := make(chan Result, len(targets))
+	run := o.newRollout(ctx, o.hasDisruptiveRestore, o.RestoreNIC)
+	for _, unit := range groupByMaster(targets) {
+		o.runUnit(run, unit, results)
+	}
+	close(results)
+
+	fmt.Printf("\n=== Restore of Original Settings (%s) ===\n", store.Path())
+	fmt.Printf("%-15s %-14s %-20s %s\n", "Interface", "PCI", "MAC Address", "Result")
+	fmt.Println(strings.Repeat("-", 110))
+
+	var errs []error
+	restored := make(map[string]bool)
+	for result := range results {
+		n := result.NIC
+		key := stateKey(n)
+		restored[key] = true
+
+		status := "UNCHANGED"
+		if result.Error != nil {
+			status = "FAILED: " + result.Error.Error()
+			o.log.Error("Error restoring %s: %v", n.Name, result.Error)
+			errs = append(errs, result.Error)
+		} else {
+			if result.Optimized {
+				status = "RESTORED"
+				o.log.Info("Restored original settings of %s", n.Name)
+			}
+			if err := store.Forget(key); err != nil {
+				errs = append(errs, fmt.Errorf("failed to update %s: %v", store.Path(), err))
+			}
+		}
+		fmt.Printf("%-15s %-14s %-20s %s\n", n.Name, orNAString(n.PCI.Address), n.MAC, status)
+	}
+
+	// NICs that are gone keep their entries for a later restore
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		if !restored[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		e := entries[key]
+		fmt.Printf("%-15s %-14s %-20s %s\n", e.Name, orNAString(e.PCI), e.MAC, "NOT FOUND")
+	}
+
+	return errors.Join(errs...)
+}
+
+// RestoreNIC puts a NIC back to the settings recorded before its first
+// change. Settings that already match are left alone.
+func (o *Optimizer) RestoreNIC(ctx context.Context, n *nic.NIC) (bool, error) {
+	o.log.Info("Restoring %s NIC: %s (Driver: %s)", n.LinkType, n.Name, n.Driver)
+
+	setters, err := o.restoreSetters(n)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	var errs []error
+	for _, set := range setters {
+		if err := set(ctx); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		changed = true
+	}
+	return changed, errors.Join(errs...)
+}
+
+// hasDisruptiveRestore reports whether RestoreNIC would put back rings,
+// channels or FEC on a NIC, the settings that drop its link
+func (o *Optimizer) hasDisruptiveRestore(n *nic.NIC) bool {
+	store, err := o.stateStore()
+	if err != nil {
+		return false
+	}
+	orig, ok := store.Get(stateKey(n))
+	if !ok {
+		return false
+	}
+	return o.restoreRings(n, orig) != nil || o.restoreChannels(n, orig) != nil || o.restoreFEC(n, orig) != nil
+}
+
+// restoreSetters returns the setters putting back the recorded settings of
+// a NIC that differ, in the order they are applied. Each restore function
+// returns the setter for one group of settings, or nil when it matches.
+func (o *Optimizer) restoreSetters(n *nic.NIC) ([]func(context.Context) error, error) {
+	store, err := o.stateStore()
+	if err != nil {
+		return nil, err
+	}
+	orig, ok := store.Get(stateKey(n))
+	if !ok {
+		return nil, nil
+	}
+
+	steps := []func(*nic.NIC, state.Entry) func(context.Context) error{
+		o.restoreRings,
+		o.restoreChannels,
+		o.restoreCoalesce,
+		o.restoreOffloads,
+		o.restorePause,
+		o.restorePrivFlags,
+		o.restoreFEC,
+	}
+	// Only the rings and the mode of IPoIB interfaces are ever changed
+	if n.LinkType == NICTypeInfiniband {
+		steps = []func(*nic.NIC, state.Entry) func(context.Context) error{
+			o.restoreRings,
+			o.restoreIPoIBMode,
+		}
+	}
+
+	var setters []func(context.Context) error
+	for _, step := range steps {
+		if set := step(n, orig); set != nil {
+			setters = append(setters, set)
+		}
+	}
+	return setters, nil
+}
+
+// restoreRings restores every ring parameter that was reported
+func (o *Optimizer) restoreRings(n *nic.NIC, orig state.Entry) func(context.Context) error {
+	p := system.RingParams{
+		RX:           sizeChange(orig.Ring.RX, n.Ring.RX, 0),
+		RXMini:       sizeChange(orig.Ring.RXMini, n.Ring.RXMini, 0),
+		RXJumbo:      sizeChange(orig.Ring.RXJumbo, n.Ring.RXJumbo, 0),
+		TX:           sizeChange(orig.Ring.TX, n.Ring.TX, 0),
+		RXBufLen:     sizeChange(orig.Ring.RXBufLen, n.Ring.RXBufLen, 0),
+		CQESize:      sizeChange(orig.Ring.CQESize, n.Ring.CQESize, 0),
+		TXPush:       flagChange(orig.Ring.TXPush, n.Ring.TXPush),
+		RXPush:       flagChange(orig.Ring.RXPush, n.Ring.RXPush),
+		TCPDataSplit: flagChange(orig.Ring.TCPDataSplit, n.Ring.TCPDataSplit),
+	}
+	if p == (system.RingParams{}) {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		if err := o.setRingParams(ctx, n.Name, p); err != nil {
+			return fmt.Errorf("failed to restore ring buffer for %s: %v", n.Name, err)
+		}
+		n.Ring = n.Ring.Merge(p)
+		return nil
+	}
+}
+
+// restoreChannels restores the channel counts
+func (o *Optimizer) restoreChannels(n *nic.NIC, orig state.Entry) func(context.Context) error {
+	c := system.Channels{
+		Combined: sizeChange(orig.Channels.Combined, n.Channels.Combined, 0),
+		RX:       sizeChange(orig.Channels.RX, n.Channels.RX, 0),
+		TX:       sizeChange(orig.Channels.TX, n.Channels.TX, 0),
+	}
+	if c == (system.Channels{}) {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		if err := o.ethtool.SetChannels(ctx, n.Name, c); err != nil {
+			return fmt.Errorf("failed to restore channels for %s: %v", n.Name, err)
+		}
+		return nil
+	}
+}
+
+// restoreCoalesce restores the coalesce parameters that differ
+func (o *Optimizer) restoreCoalesce(n *nic.NIC, orig state.Entry) func(context.Context) error {
+	c := system.Coalesce{
+		AdaptiveRX: flagChange(orig.Coalesce.AdaptiveRX, n.Coalesce.AdaptiveRX),
+		AdaptiveTX: flagChange(orig.Coalesce.AdaptiveTX, n.Coalesce.AdaptiveTX),
+		Params:     make(map[string]int),
+	}
+	for param, want := range orig.Coalesce.Params {
+		if cur, ok := n.Coalesce.Params[param]; ok && cur != want {
+			c.Params[param] = want
+		}
+	}
+	if c.AdaptiveRX == "" && c.AdaptiveTX == "" && len(c.Params) == 0 {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		if err := o.ethtool.SetCoalesce(ctx, n.Name, c); err != nil {
+			return fmt.Errorf("failed to restore coalesce parameters for %s: %v", n.Name, err)
+		}
+		return nil
+	}
+}
+
+// restoreOffloads restores the offload features that differ and can be
+// changed. A group such as tcp-segmentation-offload is on when any of its
+// features is, and setting it would set all of them, so groups whose
+// features were recorded are restored through those features instead.
+func (o *Optimizer) restoreOffloads(n *nic.NIC, orig state.Entry) func(context.Context) error {
+	changes := make(map[string]bool)
+	for name, want := range orig.Features {
+		if system.FeatureGroupExpanded(orig.Features, name) {
+			continue
+		}
+		if cur, ok := n.Features[name]; ok && !cur.Fixed && cur.Enabled != want.Enabled {
+			changes[name] = want.Enabled
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		if err := o.ethtool.SetFeatures(ctx, n.Name, changes); err != nil {
+			return fmt.Errorf("failed to restore offload features for %s: %v", n.Name, err)
+		}
+		return nil
+	}
+}
+
+// restorePause restores the pause frame settings
+func (o *Optimizer) restorePause(n *nic.NIC, orig state.Entry) func(context.Context) error {
+	p := system.PauseParams{
+		Autoneg: flagChange(orig.Pause.Autoneg, n.Pause.Autoneg),
+		RX:      flagChange(orig.Pause.RX, n.Pause.RX),
+		TX:      flagChange(orig.Pause.TX, n.Pause.TX),
+	}
+	if p == (system.PauseParams{}) {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		if err := o.ethtool.SetPause(ctx, n.Name, p); err != nil {
+			return fmt.Errorf("failed to restore pause parameters for %s: %v", n.Name, err)
+		}
+		return nil
+	}
+}
+
+// restorePrivFlags restores the private flags that differ
+func (o *Optimizer) restorePrivFlags(n *nic.NIC, orig state.Entry) func(context.Context) error {
+	changes := make(map[string]bool)
+	for name, want := range orig.PrivFlags {
+		if cur, ok := n.PrivFlags[name]; ok && cur != want {
+			changes[name] = want
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		if err := o.ethtool.SetPrivFlags(ctx, n.Name, changes); err != nil {
+			return fmt.Errorf("failed to restore private flags for %s: %v", n.Name, err)
+		}
+		return nil
+	}
+}
+
+// restoreFEC restores the configured FEC encodings
+func (o *Optimizer) restoreFEC(n *nic.NIC, orig state.Entry) func(context.Context) error {
+	want := strings.ToLower(strings.Join(orig.FEC, " "))
+	cur := strings.ToLower(strings.Join(n.FEC.Configured, " "))
+	if want == "" || cur == "" || want == cur {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		if err := o.ethtool.SetFEC(ctx, n.Name, want); err != nil {
+			return fmt.Errorf("failed to restore FEC for %s: %v", n.Name, err)
+		}
+		return nil
+	}
+}
+
+// restoreIPoIBMode restores the IPoIB mode
+func (o *Optimizer) restoreIPoIBMode(n *nic.NIC, orig state.Entry) func(context.Context) error {
+	if orig.IPoIBMode == "" || n.IPoIB.Mode == "" || orig.IPoIBMode == n.IPoIB.Mode {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		if err := o.nicMgr.SetIPoIBMode(n.Name, orig.IPoIBMode); err != nil {
+			return fmt.Errorf("failed to restore IPoIB mode for %s: %v", n.Name, err)
+		}
+		return nil
+	}
+}
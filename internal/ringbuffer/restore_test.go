@@ -0,0 +1,295 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"optimize-hpc-nic/internal/config"
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/internal/state"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// fakeEthtool records the settings changed through it
+type fakeEthtool struct {
+	calls []string
+}
+
+func (f *fakeEthtool) GetRingBufferSettings(ctx context.Context, iface string) (cur, max system.RingParams, err error) {
+	return cur, max, fmt.Errorf("no ring parameters for %s", iface)
+}
+
+func (f *fakeEthtool) SetRingParams(ctx context.Context, iface string, p system.RingParams) error {
+	f.calls = append(f.calls, fmt.Sprintf("ring %s %+v", iface, p))
+	return nil
+}
+
+func (f *fakeEthtool) SetChannels(ctx context.Context, iface string, c system.Channels) error {
+	f.calls = append(f.calls, fmt.Sprintf("channels %s %+v", iface, c))
+	return nil
+}
+
+func (f *fakeEthtool) SetCoalesce(ctx context.Context, iface string, c system.Coalesce) error {
+	f.calls = append(f.calls, fmt.Sprintf("coalesce %s %s %s %v", iface, c.AdaptiveRX, c.AdaptiveTX, c.Params))
+	return nil
+}
+
+func (f *fakeEthtool) SetFeatures(ctx context.Context, iface string, features map[string]bool) error {
+	f.calls = append(f.calls, fmt.Sprintf("features %s %v", iface, features))
+	return nil
+}
+
+func (f *fakeEthtool) SetPause(ctx context.Context, iface string, p system.PauseParams) error {
+	f.calls = append(f.calls, fmt.Sprintf("pause %s %+v", iface, p))
+	return nil
+}
+
+func (f *fakeEthtool) SetPrivFlags(ctx context.Context, iface string, flags map[string]bool) error {
+	f.calls = append(f.calls, fmt.Sprintf("priv-flags %s %v", iface, flags))
+	return nil
+}
+
+func (f *fakeEthtool) SetFEC(ctx context.Context, iface string, encoding string) error {
+	f.calls = append(f.calls, fmt.Sprintf("fec %s %s", iface, encoding))
+	return nil
+}
+
+// restoreTestNIC returns an mlx5 NIC with its settings before any change
+func restoreTestNIC() *nic.NIC {
+	return &nic.NIC{
+		Name:      "eth0",
+		MAC:       "b8:ce:f6:01:02:03",
+		PCI:       nic.PCIInfo{Address: "0000:3b:00.0"},
+		Driver:    "mlx5_core",
+		LinkType:  NICTypeEthernet,
+		Ring:      system.RingParams{RX: 1024, TX: 1024},
+		Channels:  system.Channels{Combined: 8},
+		Coalesce:  system.Coalesce{AdaptiveRX: "on", AdaptiveTX: "on", Params: map[string]int{"rx-usecs": 8, "tx-usecs": 16}},
+		Features:  map[string]system.Feature{"generic-receive-offload": {Enabled: false}, "tx-checksum-ipv4": {Enabled: false, Fixed: true}},
+		Pause:     system.PauseParams{Autoneg: "on", RX: "off", TX: "on"},
+		PrivFlags: map[string]bool{"rx_cqe_compress": false},
+		FEC:       system.FECParams{Configured: []string{"Auto", "RS"}},
+	}
+}
+
+func TestRestoreNIC(t *testing.T) {
+	cfg := &config.Config{StateFile: filepath.Join(t.TempDir(), "original.json")}
+	o := newTestOptimizer(t, cfg, t.TempDir())
+	fake := &fakeEthtool{}
+	o.ethtool = fake
+
+	n := restoreTestNIC()
+	if err := o.rememberOriginal(n); err != nil {
+		t.Fatalf("rememberOriginal: %v", err)
+	}
+
+	// The optimizer changes every setting after recording the originals
+	n.Ring = system.RingParams{RX: 8192, TX: 8192}
+	n.Channels = system.Channels{Combined: 63}
+	n.Coalesce.AdaptiveRX = "off"
+	n.Coalesce.Params["rx-usecs"] = 32
+	n.Features["generic-receive-offload"] = system.Feature{Enabled: true}
+	n.Features["tx-checksum-ipv4"] = system.Feature{Enabled: true, Fixed: true}
+	n.Pause.RX = "on"
+	n.PrivFlags["rx_cqe_compress"] = true
+	n.FEC.Configured = []string{"RS"}
+
+	if !o.hasDisruptiveRestore(n) {
+		t.Error("restoring rings, channels and FEC is not disruptive")
+	}
+	changed, err := o.RestoreNIC(context.Background(), n)
+	if !changed || err != nil {
+		t.Fatalf("RestoreNIC = %v, %v", changed, err)
+	}
+
+	// Fixed features are left alone
+	want := []string{
+		"ring eth0 " + fmt.Sprintf("%+v", system.RingParams{RX: 1024, TX: 1024}),
+		"channels eth0 " + fmt.Sprintf("%+v", system.Channels{Combined: 8}),
+		"coalesce eth0 on  map[rx-usecs:8]",
+		"features eth0 map[generic-receive-offload:false]",
+		"pause eth0 " + fmt.Sprintf("%+v", system.PauseParams{RX: "off"}),
+		"priv-flags eth0 map[rx_cqe_compress:false]",
+		"fec eth0 auto rs",
+	}
+	if !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("restored\n%s\nwant\n%s", strings.Join(fake.calls, "\n"), strings.Join(want, "\n"))
+	}
+	if n.Ring != (system.RingParams{RX: 1024, TX: 1024}) {
+		t.Errorf("rings after restore = %+v", n.Ring)
+	}
+}
+
+func TestRestoreSetters(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     func(n *nic.NIC)
+		want       []string
+		disruptive bool
+	}{
+		{
+			name:   "unchanged",
+			change: func(n *nic.NIC) {},
+		},
+		{
+			// Pause frames do not drop the link
+			name:   "pause only",
+			change: func(n *nic.NIC) { n.Pause.TX = "off" },
+			want:   []string{"pause eth0 " + fmt.Sprintf("%+v", system.PauseParams{TX: "on"})},
+		},
+		{
+			name:       "channels only",
+			change:     func(n *nic.NIC) { n.Channels = system.Channels{Combined: 63} },
+			want:       []string{"channels eth0 " + fmt.Sprintf("%+v", system.Channels{Combined: 8})},
+			disruptive: true,
+		},
+		{
+			// Settings no longer reported are not restored
+			name: "settings not reported",
+			change: func(n *nic.NIC) {
+				n.FEC.Configured = nil
+				n.Coalesce.Params = map[string]int{"rx-usecs": 8}
+				delete(n.PrivFlags, "rx_cqe_compress")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{StateFile: filepath.Join(t.TempDir(), "original.json")}
+			o := newTestOptimizer(t, cfg, t.TempDir())
+			fake := &fakeEthtool{}
+			o.ethtool = fake
+
+			n := restoreTestNIC()
+			if err := o.rememberOriginal(n); err != nil {
+				t.Fatalf("rememberOriginal: %v", err)
+			}
+			tt.change(n)
+
+			if got := o.hasDisruptiveRestore(n); got != tt.disruptive {
+				t.Errorf("hasDisruptiveRestore = %v, want %v", got, tt.disruptive)
+			}
+			changed, err := o.RestoreNIC(context.Background(), n)
+			if err != nil {
+				t.Fatalf("RestoreNIC: %v", err)
+			}
+			if changed != (len(tt.want) > 0) || !reflect.DeepEqual(fake.calls, tt.want) {
+				t.Errorf("RestoreNIC changed %v with %v, want %v", changed, fake.calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreInfiniband(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "sys/class/net/ib0")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{StateFile: filepath.Join(t.TempDir(), "original.json")}
+	o := newTestOptimizer(t, cfg, root)
+	fake := &fakeEthtool{}
+	o.ethtool = fake
+
+	n := restoreTestNIC()
+	n.Name = "ib0"
+	n.LinkType = NICTypeInfiniband
+	n.IPoIB = nic.IPoIBInfo{Mode: config.IPoIBModeDatagram}
+	if err := o.rememberOriginal(n); err != nil {
+		t.Fatalf("rememberOriginal: %v", err)
+	}
+	n.Ring = system.RingParams{RX: 8192, TX: 8192}
+	n.Channels = system.Channels{Combined: 63}
+	n.IPoIB.Mode = config.IPoIBModeConnected
+
+	// Only the rings and the mode of IPoIB interfaces are restored
+	if _, err := o.RestoreNIC(context.Background(), n); err != nil {
+		t.Fatalf("RestoreNIC: %v", err)
+	}
+	if len(fake.calls) != 1 || !strings.HasPrefix(fake.calls[0], "ring ib0 ") {
+		t.Errorf("restored %v, want only the rings", fake.calls)
+	}
+	mode, err := os.ReadFile(filepath.Join(dir, "mode"))
+	if err != nil || string(mode) != config.IPoIBModeDatagram {
+		t.Errorf("mode = %q, %v, want %s", mode, err, config.IPoIBModeDatagram)
+	}
+}
+
+func TestRestoreOffloadGroups(t *testing.T) {
+	cfg := &config.Config{StateFile: filepath.Join(t.TempDir(), "original.json")}
+	o := newTestOptimizer(t, cfg, t.TempDir())
+	fake := &fakeEthtool{}
+	o.ethtool = fake
+
+	// The group is on because one of its features is
+	n := restoreTestNIC()
+	n.Features = map[string]system.Feature{
+		"tcp-segmentation-offload": {Enabled: true},
+		"tx-tcp-segmentation":      {Enabled: true},
+		"tx-tcp-ecn-segmentation":  {Enabled: false},
+		"generic-receive-offload":  {Enabled: false},
+	}
+	if err := o.rememberOriginal(n); err != nil {
+		t.Fatalf("rememberOriginal: %v", err)
+	}
+
+	// The policy turned off the whole group and turned on GRO, listed
+	// without rx-gro as ethtool -k prints it
+	n.Features = map[string]system.Feature{
+		"tcp-segmentation-offload": {Enabled: false},
+		"tx-tcp-segmentation":      {Enabled: false},
+		"tx-tcp-ecn-segmentation":  {Enabled: false},
+		"generic-receive-offload":  {Enabled: true},
+	}
+
+	// Restoring the group would also turn on tx-tcp-ecn-segmentation
+	set := o.restoreOffloads(n, mustGetOriginal(t, o, n))
+	if set == nil {
+		t.Fatal("restoreOffloads found nothing to restore")
+	}
+	if err := set(context.Background()); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	want := []string{"features eth0 map[generic-receive-offload:false tx-tcp-segmentation:true]"}
+	if !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("restored %v, want %v", fake.calls, want)
+	}
+}
+
+// mustGetOriginal returns the settings recorded for a NIC
+func mustGetOriginal(t *testing.T, o *Optimizer, n *nic.NIC) state.Entry {
+	t.Helper()
+	store, err := o.stateStore()
+	if err != nil {
+		t.Fatalf("stateStore: %v", err)
+	}
+	orig, ok := store.Get(stateKey(n))
+	if !ok {
+		t.Fatalf("no original settings recorded for %s", n.Name)
+	}
+	return orig
+}
+
+func TestStateKey(t *testing.T) {
+	ib := func(mac string) *nic.NIC {
+		return &nic.NIC{PCI: nic.PCIInfo{Address: "0000:3b:00.0"}, MAC: mac, LinkType: NICTypeInfiniband}
+	}
+
+	// The QPN in the first bytes changes when the driver reloads
+	before := stateKey(ib("00:00:10:87:fe:80:00:00:00:00:00:00:b8:ce:f6:03:00:01:02:03"))
+	after := stateKey(ib("00:00:11:29:fe:80:00:00:00:00:00:00:b8:ce:f6:03:00:01:02:03"))
+	if before != after || before != "0000:3b:00.0/b8:ce:f6:03:00:01:02:03" {
+		t.Errorf("IPoIB keys = %s, %s, want the port GUID", before, after)
+	}
+
+	eth := &nic.NIC{PCI: nic.PCIInfo{Address: "0000:3b:00.0"}, MAC: "B8:CE:F6:01:02:03", LinkType: NICTypeEthernet}
+	if got := stateKey(eth); got != "0000:3b:00.0/b8:ce:f6:01:02:03" {
+		t.Errorf("Ethernet key = %s", got)
+	}
+}
@@ -1,6 +1,8 @@
 package ringbuffer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -9,6 +11,9 @@ import (
 	"optimize-hpc-nic/internal/config"
 	"optimize-hpc-nic/internal/logger"
 	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/internal/state"
+	"optimize-hpc-nic/internal/stats"
+	"optimize-hpc-nic/pkg/system"
 )
 
 // 网卡类型常量
@@ -31,6 +36,11 @@ type Optimizer struct {
 	log     *logger.Logger
 	cfg     *config.Config
 	ethtool ethtool
+
+	// Original NIC settings, loaded on first use
+	stateOnce sync.Once
+	state     *state.Store
+	stateErr  error
 }
 
 // New creates a new Optimizer
@@ -39,66 +49,190 @@ func New(nicMgr *nic.Manager, log *logger.Logger, cfg *config.Config) *Optimizer
 		nicMgr:  nicMgr,
 		log:     log,
 		cfg:     cfg,
-		ethtool: &ethtoolWrapper{log: log},
+		ethtool: &ethtoolWrapper{log: log, ethtool: nicMgr.Ethtool()},
 	}
 }
 
 // ethtool interface defines methods for interacting with ethtool
 type ethtool interface {
-	SetRingBufferSettings(iface string, rx, tx int) error
+	GetRingBufferSettings(ctx context.Context, iface string) (cur, max system.RingParams, err error)
+	SetRingParams(ctx context.Context, iface string, p system.RingParams) error
+	SetChannels(ctx context.Context, iface string, c system.Channels) error
+	SetCoalesce(ctx context.Context, iface string, c system.Coalesce) error
+	SetFeatures(ctx context.Context, iface string, features map[string]bool) error
+	SetPause(ctx context.Context, iface string, p system.PauseParams) error
+	SetPrivFlags(ctx context.Context, iface string, flags map[string]bool) error
+	SetFEC(ctx context.Context, iface string, encoding string) error
 }
 
-// ethtoolWrapper wraps ethtool commands
+// ethtoolWrapper wraps the ethtool instance shared with the NIC manager
 type ethtoolWrapper struct {
-	log *logger.Logger
+	log     *logger.Logger
+	ethtool *system.Ethtool
 }
 
-// SetRingBufferSettings sets ring buffer settings
-func (e *ethtoolWrapper) SetRingBufferSettings(iface string, rx, tx int) error {
-	e.log.Debug("Setting ring buffer for %s: RX=%d, TX=%d", iface, rx, tx)
-	cmd := fmt.Sprintf("ethtool -G %s rx %d tx %d", iface, rx, tx)
-	_, err := system.RunCommand(cmd)
-	return err
+// GetRingBufferSettings reads the current and maximum ring parameters
+func (e *ethtoolWrapper) GetRingBufferSettings(ctx context.Context, iface string) (cur, max system.RingParams, err error) {
+	return e.ethtool.GetRingBufferSettings(ctx, iface)
 }
 
-// OptimizeNIC optimizes a single NIC's ring buffer settings
-func (o *Optimizer) OptimizeNIC(nic *nic.NIC) (bool, error) {
-	// Skip Infiniband interfaces
-	if nic.LinkType == NICTypeInfiniband {
-		o.log.Info("Skipping Infiniband interface %s (not supported for ring buffer optimization)", nic.Name)
-		return false, nil
+// SetRingParams sets ring buffer settings
+func (e *ethtoolWrapper) SetRingParams(ctx context.Context, iface string, p system.RingParams) error {
+	e.log.Debug("Setting ring buffer for %s: %+v", iface, p)
+	return e.ethtool.SetRingParams(ctx, iface, p)
+}
+
+// SetChannels sets channel counts
+func (e *ethtoolWrapper) SetChannels(ctx context.Context, iface string, c system.Channels) error {
+	e.log.Debug("Setting channels for %s: %+v", iface, c)
+	return e.ethtool.SetChannels(ctx, iface, c)
+}
+
+// SetCoalesce sets interrupt coalescing parameters
+func (e *ethtoolWrapper) SetCoalesce(ctx context.Context, iface string, c system.Coalesce) error {
+	e.log.Debug("Setting coalesce parameters for %s: %+v", iface, c)
+	return e.ethtool.SetCoalesce(ctx, iface, c)
+}
+
+// SetFeatures enables or disables offload features
+func (e *ethtoolWrapper) SetFeatures(ctx context.Context, iface string, features map[string]bool) error {
+	e.log.Debug("Setting offload features for %s: %v", iface, features)
+	return e.ethtool.SetFeatures(ctx, iface, features)
+}
+
+// SetPause sets pause frame settings
+func (e *ethtoolWrapper) SetPause(ctx context.Context, iface string, p system.PauseParams) error {
+	e.log.Debug("Setting pause parameters for %s: %+v", iface, p)
+	return e.ethtool.SetPause(ctx, iface, p)
+}
+
+// SetPrivFlags sets driver private flags
+func (e *ethtoolWrapper) SetPrivFlags(ctx context.Context, iface string, flags map[string]bool) error {
+	e.log.Debug("Setting private flags for %s: %v", iface, flags)
+	return e.ethtool.SetPrivFlags(ctx, iface, flags)
+}
+
+// SetFEC sets the FEC encoding
+func (e *ethtoolWrapper) SetFEC(ctx context.Context, iface string, encoding string) error {
+	e.log.Debug("Setting FEC encoding for %s: %s", iface, encoding)
+	return e.ethtool.SetFEC(ctx, iface, encoding)
+}
+
+// OptimizeNIC applies every managed setting to a single NIC. A failing
+// setting does not prevent the remaining ones from being applied.
+func (o *Optimizer) OptimizeNIC(ctx context.Context, n *nic.NIC) (bool, error) {
+	o.log.Info("Optimizing %s NIC: %s (Speed: %dMbps, Driver: %s)", n.LinkType, n.Name, n.Speed, n.Driver)
+
+	// Keep the settings from before the first change so restore mode can
+	// put them back; a NIC whose settings cannot be kept is not changed
+	if o.hasPendingChanges(n) {
+		if err := o.rememberOriginal(n); err != nil {
+			return false, fmt.Errorf("not changing %s: failed to record its original settings: %v", n.Name, err)
+		}
+	}
+
+	steps := []func(context.Context, *nic.NIC) (bool, error){
+		o.optimizeRings,
+		o.optimizeChannels,
+		o.optimizeCoalesce,
+		o.optimizeOffloads,
+		o.optimizePause,
+		o.optimizePrivFlags,
+		o.optimizeFEC,
+	}
+	// IPoIB interfaces only have send/receive rings and the IPoIB mode
+	if n.LinkType == NICTypeInfiniband {
+		steps = []func(context.Context, *nic.NIC) (bool, error){
+			o.optimizeRings,
+			o.optimizeIPoIBMode,
+		}
 	}
 
+	optimized := false
+	var errs []error
+	for _, step := range steps {
+		changed, err := step(ctx, n)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		optimized = optimized || changed
+	}
+
+	return optimized, errors.Join(errs...)
+}
+
+// hasPendingChanges reports whether OptimizeNIC would change anything on a
+// NIC
+func (o *Optimizer) hasPendingChanges(n *nic.NIC) bool {
+	return len(o.PlanNIC(n)) > 0
+}
+
+// hasDisruptiveChanges reports whether OptimizeNIC would change the rings,
+// channels or FEC of a NIC, the settings that drop its link
+func (o *Optimizer) hasDisruptiveChanges(n *nic.NIC) bool {
+	if len(o.planRings(n)) > 0 {
+		return true
+	}
+	// Only the rings of IPoIB interfaces are ever changed
+	if n.LinkType == NICTypeInfiniband {
+		return false
+	}
+	return len(o.planChannels(n)) > 0 || len(o.planFEC(n)) > 0
+}
+
+// optimizeRings sizes RX/TX rings as the ring rules require (the pre-set
+// maximum by default) and applies the other ring parameters from the config
+// file
+func (o *Optimizer) optimizeRings(ctx context.Context, nic *nic.NIC) (bool, error) {
+	// Ring parameters from the config file that differ from the hardware
+	extra := o.ringPolicyChanges(nic)
+
 	// Check if already optimized
-	if nic.IsOptimal {
+	if nic.IsOptimal && extra == (system.RingParams{}) {
 		o.log.Debug("%s is already optimized (RX: %d/%d, TX: %d/%d)",
-			nic.Name, nic.RXCurrent, nic.RXMax, nic.TXCurrent, nic.TXMax)
+			nic.Name, nic.Ring.RX, nic.RingTarget.RX, nic.Ring.TX, nic.RingTarget.TX)
 		return false, nil
 	}
 
 	// Skip if max values are not available
-	if nic.RXMax <= 0 || nic.TXMax <= 0 {
-		return false, fmt.Errorf("invalid max values for %s: RX=%d, TX=%d", nic.Name, nic.RXMax, nic.TXMax)
+	if nic.RingMax.RX <= 0 || nic.RingMax.TX <= 0 {
+		return false, fmt.Errorf("invalid max values for %s: RX=%d, TX=%d", nic.Name, nic.RingMax.RX, nic.RingMax.TX)
 	}
 
 	// Optimize the NIC
-	err := o.ethtool.SetRingBufferSettings(nic.Name, nic.RXMax, nic.TXMax)
+	target := extra
+	target.RX = nic.RingTarget.RX
+	target.TX = nic.RingTarget.TX
+	if nic.RingRule != "" {
+		o.log.Debug("Sizing rings of %s to RX %d, TX %d by %s", nic.Name, target.RX, target.TX, nic.RingRule)
+	}
+	err := o.setRingParams(ctx, nic.Name, target)
 	if err != nil {
 		return false, fmt.Errorf("failed to set ring buffer for %s: %v", nic.Name, err)
 	}
 
-	// Update NIC object to reflect new settings
-	nic.RXCurrent = nic.RXMax
-	nic.TXCurrent = nic.TXMax
-	nic.IsOptimal = true
+	// Drivers may round the sizes or silently keep the old ones, so the
+	// result is read back instead of assumed
+	cur, max, err := o.ethtool.GetRingBufferSettings(ctx, nic.Name)
+	if err != nil {
+		nic.IsOptimal = false
+		return true, fmt.Errorf("failed to read back ring buffer of %s: %v", nic.Name, err)
+	}
+	nic.Ring, nic.RingMax = cur, max
+	nic.RingMismatches = ringMismatches(target, cur)
+	nic.IsOptimal = cur.RX == nic.RingTarget.RX && cur.TX == nic.RingTarget.TX
+	if len(nic.RingMismatches) > 0 {
+		return true, fmt.Errorf("ring buffer of %s differs after set: %s", nic.Name, strings.Join(nic.RingMismatches, ", "))
+	}
 
 	return true, nil
 }
 
-// OptimizeAll optimizes all high-speed NICs
-func (o *Optimizer) OptimizeAll(showAll bool) ([]*nic.NIC, error) {
+// OptimizeAll optimizes all high-speed NICs and returns every NIC it
+// discovered, with the settings it left them in
+func (o *Optimizer) OptimizeAll(ctx context.Context, showAll bool) ([]*nic.NIC, error) {
 	// Get all NICs
-	nics, err := o.nicMgr.GetHighSpeedNICs()
+	nics, err := o.nicMgr.GetHighSpeedNICs(ctx)
 	if err != nil {
 		o.log.Error("Error getting NICs: %v", err)
 		return nil, err
@@ -107,35 +241,37 @@ func (o *Optimizer) OptimizeAll(showAll bool) ([]*nic.NIC, error) {
 	o.log.Info("Found %d high-speed physical NICs (≥%dMbps)", len(nics), o.cfg.MinSpeed)
 
 	// 分类网卡
-	var ethernetNICs []*nic.NIC
-	var infinibandNICs []*nic.NIC
-
+	var targets []*nic.NIC
+	var skipped []*nic.NIC
+	infinibandCount := 0
 	for _, n := range nics {
+		if n.SkipReason = o.skipReason(n); n.SkipReason != "" {
+			o.log.Info("Skipping %s: %s", n.Name, n.SkipReason)
+			skipped = append(skipped, n)
+			continue
+		}
 		if n.LinkType == NICTypeInfiniband {
-			o.log.Info("Skipping Infiniband interface %s (not supported for ring buffer optimization)", n.Name)
-			infinibandNICs = append(infinibandNICs, n)
-		} else {
-			ethernetNICs = append(ethernetNICs, n)
+			infinibandCount++
 		}
+		targets = append(targets, n)
 	}
 
-	o.log.Info("Found %d Ethernet interfaces for optimization (skipped %d Infiniband interfaces)",
-		len(ethernetNICs), len(infinibandNICs))
+	o.log.Info("Found %d Ethernet and %d Infiniband interfaces for optimization",
+		len(targets)-infinibandCount, infinibandCount)
 
-	if len(ethernetNICs) == 0 {
-		o.log.Info("No Ethernet interfaces to optimize")
+	if len(targets) == 0 {
+		o.log.Info("No interfaces to optimize")
 
 		if showAll {
-			// 即使没有要优化的以太网接口，也显示所有网卡（包括Infiniband）
 			fmt.Println("\n=== Configuration Results for High-Speed NICs (≥200G) ===")
 			DisplayFormattedResults(nics)
 		}
 
-		return nil, nil
+		return nics, nil
 	}
 
 	// 创建结果通道
-	results := make(chan Result, len(ethernetNICs))
+	results := make(chan Result, len(targets))
 
 	// 创建等待组
 	var wg sync.WaitGroup
@@ -143,19 +279,18 @@ func (o *Optimizer) OptimizeAll(showAll bool) ([]*nic.NIC, error) {
 	// 创建工作池
 	workers := make(chan struct{}, o.cfg.MaxWorkers)
 
-	// 处理每个Ethernet NIC
-	for _, n := range ethernetNICs {
+	// 处理每个NIC; slaves of the same bond or team are changed one at a time
+	run := o.newRollout(ctx, o.hasDisruptiveChanges, o.OptimizeNIC)
+	for _, unit := range groupByMaster(targets) {
 		wg.Add(1)
 		workers <- struct{}{} // 获取工作者
 
-		go func(n *nic.NIC) {
+		go func(unit []*nic.NIC) {
 			defer wg.Done()
 			defer func() { <-workers }() // 释放工作者
 
-			o.log.Info("Optimizing Ethernet NIC: %s (Speed: %dMbps, Driver: %s)", n.Name, n.Speed, n.Driver)
-			optimized, err := o.OptimizeNIC(n)
-			results <- Result{NIC: n, Optimized: optimized, Error: err}
-		}(n)
+			o.runUnit(run, unit, results)
+		}(unit)
 	}
 
 	// 等待所有工作者完成
@@ -166,8 +301,7 @@ func (o *Optimizer) OptimizeAll(showAll bool) ([]*nic.NIC, error) {
 
 	// 处理结果
 	optimizedCount := 0
-	var optimizedNICs []*nic.NIC
-	var processedNICs []*nic.NIC // 处理过的以太网网卡
+	var processedNICs []*nic.NIC // 处理过的网卡
 
 	for result := range results {
 		n := result.NIC
@@ -176,39 +310,29 @@ func (o *Optimizer) OptimizeAll(showAll bool) ([]*nic.NIC, error) {
 		if result.Error != nil {
 			o.log.Error("Error optimizing %s: %v", n.Name, result.Error)
 		} else if result.Optimized {
-			o.log.Info("Successfully optimized %s (RX: %d, TX: %d)", n.Name, n.RXMax, n.TXMax)
+			o.log.Info("Successfully optimized %s (RX: %d, TX: %d)", n.Name, n.Ring.RX, n.Ring.TX)
 			optimizedCount++
-			optimizedNICs = append(optimizedNICs, n)
 		} else {
 			o.log.Info("%s already optimized (RX: %d/%d, TX: %d/%d)",
-				n.Name, n.RXCurrent, n.RXMax, n.TXCurrent, n.TXMax)
-			if n.IsOptimal {
-				optimizedNICs = append(optimizedNICs, n)
-			}
+				n.Name, n.Ring.RX, n.RingTarget.RX, n.Ring.TX, n.RingTarget.TX)
 		}
 	}
 
-	o.log.Info("Optimization complete: %d of %d Ethernet NICs optimized", optimizedCount, len(ethernetNICs))
+	o.log.Info("Optimization complete: %d of %d NICs optimized", optimizedCount, len(targets))
 
 	// 显示结果
 	if showAll {
 		fmt.Println("\n=== Configuration Results for High-Speed NICs (≥200G) ===")
-
-		// 合并所有网卡列表以便显示
-		var allNICs []*nic.NIC
-		allNICs = append(allNICs, infinibandNICs...) // 先添加Infiniband网卡
-		allNICs = append(allNICs, processedNICs...)  // 再添加处理过的以太网网卡
-
-		DisplayFormattedResults(allNICs) // 显示所有网卡
+		DisplayFormattedResults(append(skipped, processedNICs...)) // 显示所有网卡
 	}
 
-	return optimizedNICs, nil
+	return nics, nil
 }
 
 // Query displays current ring buffer settings
-func (o *Optimizer) Query() error {
+func (o *Optimizer) Query(ctx context.Context) error {
 	// 获取所有高速NIC
-	nics, err := o.nicMgr.GetHighSpeedNICs()
+	nics, err := o.nicMgr.GetHighSpeedNICs(ctx)
 	if err != nil {
 		o.log.Error("Error querying NICs: %v", err)
 		return err
@@ -229,38 +353,84 @@ func (o *Optimizer) Query() error {
 	o.log.Info("Found %d high-speed physical NICs (≥%dMbps): %d Ethernet, %d Infiniband",
 		len(nics), o.cfg.MinSpeed, len(ethernetNICs), len(infinibandNICs))
 
+	// Compare settings against the policy without changing anything
+	for _, n := range nics {
+		n.SkipReason = o.skipReason(n)
+	}
+	for _, n := range ethernetNICs {
+		_, n.OffloadMismatches = o.offloadChanges(n)
+		_, n.PrivFlagMismatches = o.privFlagChanges(n)
+		n.FECMismatch = o.fecChange(n)
+	}
+
 	// 显示所有网卡的结果
 	fmt.Println("\n=== Configuration Results for All High-Speed NICs (≥200G) ===")
 	DisplayFormattedResults(nics) // 显示所有网卡，包括Infiniband
 
+	// Drop and error counters, sampled twice when an interval is set.
+	// Unresponsive NICs are not asked again.
+	responsive := respondingNICs(nics)
+	collector := stats.NewCollector(o.nicMgr.Ethtool(), o.nicMgr.FS())
+	if o.cfg.StatsInterval > 0 {
+		for _, n := range responsive {
+			collector.Collect(ctx, n.Name)
+		}
+		select {
+		case <-time.After(time.Duration(o.cfg.StatsInterval) * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	counters := make(map[string][]stats.Delta)
+	for _, n := range responsive {
+		deltas, err := collector.Collect(ctx, n.Name)
+		if err != nil {
+			o.log.Debug("%v", err)
+			continue
+		}
+		counters[n.Name] = stats.Watched(deltas)
+	}
+	displayStats(nics, counters)
+
 	return nil
 }
 
-// Monitor continuously checks and optimizes ring buffer settings
-func (o *Optimizer) Monitor(interval int) error {
+// Monitor continuously checks and optimizes ring buffer settings until
+// ctx is cancelled
+func (o *Optimizer) Monitor(ctx context.Context, interval int) error {
 	o.log.Info("Starting monitor mode with interval: %d seconds", interval)
 
 	for {
 		o.log.Info("Checking ring buffer settings...")
-		_, err := o.OptimizeAll(false) // 优化但不显示详细结果
+		// 显示所有网卡，包括Infiniband接口
+		allNICs, err := o.OptimizeAll(ctx, false) // 优化但不显示详细结果
 		if err != nil {
 			o.log.Error("Error during optimization: %v", err)
-		}
-
-		// 获取所有NIC以显示完整状态，包括Infiniband接口
-		allNICs, err := o.nicMgr.GetHighSpeedNICs()
-		if err != nil {
-			o.log.Error("Error getting NICs: %v", err)
 		} else {
 			fmt.Println("\n=== Current Configuration of High-Speed NICs (≥200G) ===")
-			DisplayFormattedResults(allNICs) // 显示所有网卡
+			DisplayFormattedResults(allNICs)
 		}
 
 		o.log.Info("Sleeping for %d seconds...", interval)
-		time.Sleep(time.Duration(interval) * time.Second)
+		select {
+		case <-time.After(time.Duration(interval) * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
 
+// respondingNICs returns the NICs whose discovery finished
+func respondingNICs(nics []*nic.NIC) []*nic.NIC {
+	var responsive []*nic.NIC
+	for _, n := range nics {
+		if !n.Unresponsive {
+			responsive = append(responsive, n)
+		}
+	}
+	return responsive
+}
+
 // DisplayFormattedResults formats and displays NIC information
 func DisplayFormattedResults(nics []*nic.NIC) {
 	// 打印表头
@@ -279,23 +449,23 @@ func DisplayFormattedResults(nics []*nic.NIC) {
 
 		if n.LinkType == NICTypeInfiniband {
 			infinibandCount++
-			status = "SKIPPED"  // Infiniband接口标记为已跳过
-			ringBuffer := "N/A" // Infiniband接口无需显示环形缓冲区设置
-
-			fmt.Printf("%-15s %-12d %-10s %-15s %-20s %-25s %-15s\n",
-				n.Name, n.Speed, n.LinkType, n.Driver, n.MAC, ringBuffer, status)
 		} else {
 			ethernetCount++
-			// Ethernet接口
-			if n.IsOptimal {
-				optimizedCount++
-				status = "OPTIMIZED"
-			}
-
-			ringBuffer := fmt.Sprintf("%d/%d", n.RXCurrent, n.TXCurrent)
-			fmt.Printf("%-15s %-12d %-10s %-15s %-20s %-25s %-15s\n",
-				n.Name, n.Speed, n.LinkType, n.Driver, n.MAC, ringBuffer, status)
 		}
+		if n.Unresponsive {
+			status = "UNRESPONSIVE"
+		} else if n.SkipReason != "" {
+			status = "SKIPPED"
+		} else if len(n.RingMismatches) > 0 {
+			status = "APPLIED_MISMATCH"
+		} else if n.IsOptimal {
+			optimizedCount++
+			status = "OPTIMIZED"
+		}
+
+		ringBuffer := fmt.Sprintf("%d/%d", n.Ring.RX, n.Ring.TX)
+		fmt.Printf("%-15s %-12d %-10s %-15s %-20s %-25s %-15s\n",
+			n.Name, n.Speed, n.LinkType, n.Driver, n.MAC, ringBuffer, status)
 	}
 
 	if len(nics) == 0 {
@@ -306,13 +476,38 @@ func DisplayFormattedResults(nics []*nic.NIC) {
 		fmt.Printf("SUMMARY: Total: %d NICs | Ethernet: %d | Infiniband: %d | Optimized: %d\n",
 			len(nics), ethernetCount, infinibandCount, optimizedCount)
 
+		for _, n := range nics {
+			if n.Unresponsive {
+				fmt.Printf("NOTE: %s is unresponsive: %s\n", n.Name, n.SkipReason)
+			} else if n.SkipReason != "" {
+				fmt.Printf("NOTE: %s is skipped: %s\n", n.Name, n.SkipReason)
+			} else if len(n.RingMismatches) > 0 {
+				fmt.Printf("NOTE: %s kept different ring settings than requested: %s\n",
+					n.Name, strings.Join(n.RingMismatches, ", "))
+			}
+		}
+
+		// Unresponsive NICs have no settings to show
+		nics = respondingNICs(nics)
+
+		displayLinks(nics)
+		displayPCI(nics)
+		displayRDMA(nics)
+		displayBonds(nics)
+		displayRingTargets(nics)
+
 		if infinibandCount > 0 {
-			fmt.Println("NOTE: Infiniband interfaces are skipped as ring buffer optimization is not applicable")
+			displayIPoIB(nics)
 		}
-	}
-}
 
-// 提供一个简单的RunCommand接口以供系统命令执行，避免需要导入pkg/system包
-var system struct {
-	RunCommand func(cmd string) (string, error)
+		if ethernetCount > 0 {
+			displayRingParams(nics)
+			displayChannels(nics)
+			displayCoalesce(nics)
+			displayOffloads(nics)
+			displayPause(nics)
+			displayPrivFlags(nics)
+			displayFEC(nics)
+		}
+	}
 }
@@ -0,0 +1,160 @@
+package ringbuffer
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"optimize-hpc-nic/internal/config"
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// replayNIC reads the settings of a NIC through e the way discovery does,
+// with the rings sized to their pre-set maximum
+func replayNIC(t *testing.T, e *system.Ethtool, name, linkType string) *nic.NIC {
+	t.Helper()
+	ctx := context.Background()
+	n := &nic.NIC{Name: name, Driver: "mlx5_core", LinkType: linkType}
+
+	var err error
+	if n.Ring, n.RingMax, err = e.GetRingBufferSettings(ctx, name); err != nil {
+		t.Fatalf("GetRingBufferSettings(%s): %v", name, err)
+	}
+	n.RingTarget = system.RingParams{RX: n.RingMax.RX, TX: n.RingMax.TX}
+	n.IsOptimal = n.Ring.RX == n.RingTarget.RX && n.Ring.TX == n.RingTarget.TX
+	if linkType == NICTypeInfiniband {
+		return n
+	}
+
+	if n.Channels, n.ChannelsMax, err = e.GetChannels(ctx, name); err != nil {
+		t.Fatalf("GetChannels(%s): %v", name, err)
+	}
+	if n.Coalesce, err = e.GetCoalesce(ctx, name); err != nil {
+		t.Fatalf("GetCoalesce(%s): %v", name, err)
+	}
+	if n.Features, err = e.GetFeatures(ctx, name); err != nil {
+		t.Fatalf("GetFeatures(%s): %v", name, err)
+	}
+	if n.Pause, err = e.GetPause(ctx, name); err != nil {
+		t.Fatalf("GetPause(%s): %v", name, err)
+	}
+	if n.PrivFlags, err = e.GetPrivFlags(ctx, name); err != nil {
+		t.Fatalf("GetPrivFlags(%s): %v", name, err)
+	}
+	if n.FEC, err = e.GetFEC(ctx, name); err != nil {
+		t.Fatalf("GetFEC(%s): %v", name, err)
+	}
+	return n
+}
+
+func TestOptimizeNICReplay(t *testing.T) {
+	fixture, err := system.LoadFixture("../../pkg/system/testdata/mlx5.json")
+	if err != nil {
+		t.Fatalf("LoadFixture: %v", err)
+	}
+
+	// The rings read back after the change, and the changes the policy
+	// makes beyond the recorded ring change
+	for _, iface := range []string{"eth0", "ib0"} {
+		fixture.Commands = append(fixture.Commands, system.FixtureEntry{
+			Command: "ethtool",
+			Args:    []string{"-g", iface},
+			Output: "Ring parameters for " + iface + ":\nPre-set maximums:\nRX:\t\t8192\nTX:\t\t8192\n" +
+				"Current hardware settings:\nRX:\t\t8192\nTX:\t\t8192\n",
+		})
+	}
+	fixture.Commands = append(fixture.Commands,
+		system.FixtureEntry{Command: "ethtool", Args: []string{"-L", "eth0", "combined", "63"}},
+		system.FixtureEntry{Command: "ethtool", Args: []string{"-C", "eth0", "adaptive-rx", "off", "rx-usecs", "32"}},
+	)
+
+	// Offloads, pause frames, private flags and FEC already match
+	on, off := true, false
+	cfg := &config.Config{
+		SetRetries:   1,
+		RetryBackoff: 1,
+		Policy: config.Policy{
+			Channels:  config.ChannelPolicy{Policy: config.ChannelPolicyMax},
+			Coalesce:  map[string]config.CoalesceTarget{"eth*": {AdaptiveRX: "off", Params: map[string]int{"rx-usecs": 32, "tx-usecs": 8}}},
+			Offloads:  map[string]map[string]bool{"eth*": {"large-receive-offload": true, "generic-receive-offload": false}},
+			Pause:     map[string]config.PauseTarget{"eth*": {RX: &off, TX: &on}},
+			PrivFlags: map[string]map[string]bool{"mlx5_core": {"rx_striding_rq": true}},
+		},
+	}
+	o := newTestOptimizer(t, cfg, t.TempDir())
+
+	record := filepath.Join(t.TempDir(), "record.json")
+	e, err := system.NewEthtoolBackend(system.BackendExec, system.NewRecordingRunner(system.NewReplayRunner(fixture), record), 0)
+	if err != nil {
+		t.Fatalf("NewEthtoolBackend: %v", err)
+	}
+	o.ethtool = &ethtoolWrapper{log: o.log, ethtool: e}
+
+	for _, tt := range []struct {
+		name     string
+		linkType string
+	}{
+		{"eth0", NICTypeEthernet},
+		{"ib0", NICTypeInfiniband},
+	} {
+		n := replayNIC(t, e, tt.name, tt.linkType)
+		changed, err := o.OptimizeNIC(context.Background(), n)
+		if !changed || err != nil {
+			t.Errorf("OptimizeNIC(%s) = %v, %v", tt.name, changed, err)
+		}
+		if !n.IsOptimal || n.Ring.RX != 8192 || n.Ring.TX != 8192 {
+			t.Errorf("%s rings after OptimizeNIC = %+v, optimal %v", tt.name, n.Ring, n.IsOptimal)
+		}
+	}
+
+	recorded, err := system.LoadFixture(record)
+	if err != nil {
+		t.Fatalf("LoadFixture: %v", err)
+	}
+	var issued []string
+	for _, entry := range recorded.Commands {
+		switch entry.Args[0] {
+		case "-G", "-L", "-C", "-K", "-A", "--set-priv-flags", "--set-fec":
+			issued = append(issued, strings.Join(entry.Args, " "))
+		}
+	}
+
+	// The busy ib0 ring change is retried
+	want := []string{
+		"-G eth0 rx 8192 tx 8192",
+		"-L eth0 combined 63",
+		"-C eth0 adaptive-rx off rx-usecs 32",
+		"-G ib0 rx 8192 tx 8192",
+		"-G ib0 rx 8192 tx 8192",
+	}
+	if !reflect.DeepEqual(issued, want) {
+		t.Errorf("issued\n%s\nwant\n%s", strings.Join(issued, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestOptimizeFEC(t *testing.T) {
+	cfg := &config.Config{Policy: config.Policy{FEC: map[int]string{400000: "RS"}}}
+	o := newTestOptimizer(t, cfg, t.TempDir())
+	fake := &fakeEthtool{}
+	o.ethtool = fake
+
+	n := &nic.NIC{Name: "eth0", Speed: 400000, FEC: system.FECParams{Configured: []string{"Auto", "RS"}, Active: "RS"}}
+	changed, err := o.optimizeFEC(context.Background(), n)
+	if !changed || err != nil {
+		t.Fatalf("optimizeFEC = %v, %v", changed, err)
+	}
+	if !reflect.DeepEqual(fake.calls, []string{"fec eth0 rs"}) {
+		t.Errorf("set %v, want fec eth0 rs", fake.calls)
+	}
+
+	// The NIC reflects the new encoding, so a second pass changes nothing
+	if !reflect.DeepEqual(n.FEC.Configured, []string{"RS"}) || n.FECMismatch != "" {
+		t.Errorf("FEC after optimizeFEC = %v, mismatch %q", n.FEC.Configured, n.FECMismatch)
+	}
+	if changed, _ := o.optimizeFEC(context.Background(), n); changed {
+		t.Error("optimizeFEC changed FEC again")
+	}
+}
@@ -0,0 +1,100 @@
+package ringbuffer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// ringPolicyChanges returns the ring parameters from the config file that
+// differ from the NIC's current settings. Parameters the driver does not
+// report are left alone.
+func (o *Optimizer) ringPolicyChanges(n *nic.NIC) system.RingParams {
+	p := o.cfg.Policy.Ring
+	return system.RingParams{
+		RXMini:       sizeChange(p.RXMini, n.Ring.RXMini, n.RingMax.RXMini),
+		RXJumbo:      sizeChange(p.RXJumbo, n.Ring.RXJumbo, n.RingMax.RXJumbo),
+		RXBufLen:     sizeChange(p.RXBufLen, n.Ring.RXBufLen, 0),
+		CQESize:      sizeChange(p.CQESize, n.Ring.CQESize, 0),
+		TXPush:       flagChange(p.TXPush, n.Ring.TXPush),
+		RXPush:       flagChange(p.RXPush, n.Ring.RXPush),
+		TCPDataSplit: flagChange(p.TCPDataSplit, n.Ring.TCPDataSplit),
+	}
+}
+
+// sizeChange returns the size to set, clamped to max when the driver
+// reports one, or 0 if nothing needs to change
+func sizeChange(want, cur, max int) int {
+	if want <= 0 || (cur <= 0 && max <= 0) {
+		return 0
+	}
+	if max > 0 && want > max {
+		want = max
+	}
+	if want == cur {
+		return 0
+	}
+	return want
+}
+
+// flagChange returns the value to set, or "" if nothing needs to change
+func flagChange(want, cur string) string {
+	if want == "" || cur == "" || want == cur {
+		return ""
+	}
+	return want
+}
+
+// setRingParams sets ring parameters, retrying with exponential backoff
+// while the device reports EBUSY
+func (o *Optimizer) setRingParams(ctx context.Context, name string, p system.RingParams) error {
+	backoff := time.Duration(o.cfg.RetryBackoff) * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := o.ethtool.SetRingParams(ctx, name, p)
+		if err == nil || !system.IsBusy(err) || attempt >= o.cfg.SetRetries {
+			return err
+		}
+		o.log.Info("%s is busy, retrying ring change in %v (%d/%d)", name, backoff, attempt+1, o.cfg.SetRetries)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// ringMismatches compares the parameters that were set with the ones read
+// back and describes every difference
+func ringMismatches(set, got system.RingParams) []string {
+	var mismatches []string
+	for _, f := range []struct {
+		name     string
+		set, got int
+	}{
+		{"rx", set.RX, got.RX},
+		{"rx-mini", set.RXMini, got.RXMini},
+		{"rx-jumbo", set.RXJumbo, got.RXJumbo},
+		{"tx", set.TX, got.TX},
+		{"rx-buf-len", set.RXBufLen, got.RXBufLen},
+		{"cqe-size", set.CQESize, got.CQESize},
+	} {
+		if f.set > 0 && f.got != f.set {
+			mismatches = append(mismatches, fmt.Sprintf("%s %d (requested %d)", f.name, f.got, f.set))
+		}
+	}
+	for _, f := range []struct{ name, set, got string }{
+		{"tx-push", set.TXPush, got.TXPush},
+		{"rx-push", set.RXPush, got.RXPush},
+		{"tcp-data-split", set.TCPDataSplit, got.TCPDataSplit},
+	} {
+		// auto reads back as the mode the driver picked
+		if f.set != "" && f.set != "auto" && f.got != f.set {
+			mismatches = append(mismatches, fmt.Sprintf("%s %s (requested %s)", f.name, orNAString(f.got), f.set))
+		}
+	}
+	return mismatches
+}
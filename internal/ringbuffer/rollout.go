@@ -0,0 +1,168 @@
+package ringbuffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"optimize-hpc-nic/internal/nic"
+)
+
+// How often a changed NIC's state is polled while waiting for it to come back
+const linkPollInterval = time.Second
+
+// rollout is one run of changes (OptimizeAll or Restore) across NICs. At
+// most -max-disruptions NICs with changes that drop the link are changed at
+// the same time, and once such a NIC does not come back, no other NIC is
+// changed for the rest of the run.
+type rollout struct {
+	ctx         context.Context
+	disruptive  func(*nic.NIC) bool                           // whether apply would drop the link
+	apply       func(context.Context, *nic.NIC) (bool, error) // OptimizeNIC or RestoreNIC
+	disruptions chan struct{}                                 // nil = no limit
+
+	mu      sync.Mutex
+	haltErr error
+}
+
+// newRollout starts a run applying a change to NICs
+func (o *Optimizer) newRollout(ctx context.Context, disruptive func(*nic.NIC) bool, apply func(context.Context, *nic.NIC) (bool, error)) *rollout {
+	r := &rollout{ctx: ctx, disruptive: disruptive, apply: apply}
+	if o.cfg.MaxDisruptions > 0 {
+		r.disruptions = make(chan struct{}, o.cfg.MaxDisruptions)
+	}
+	return r
+}
+
+// runUnit applies the change to the NICs of a unit in order, so slaves of
+// the same bond or team are never down together. If a slave does not come
+// back, the remaining slaves are left alone and the bond keeps at least one
+// working member.
+func (o *Optimizer) runUnit(r *rollout, unit []*nic.NIC, results chan<- Result) {
+	for _, n := range unit {
+		changed, err := o.change(r, n)
+		results <- Result{NIC: n, Optimized: changed, Error: err}
+	}
+}
+
+// change applies the change to a NIC. Changing rings or channels resets the
+// queues and changing FEC retrains the link, so a NIC with any of these to
+// change holds one of the -max-disruptions slots while it is changed and, if
+// its link was up, until it is back in service and has settled. Coalesce,
+// offload, pause, private flag and IPoIB mode changes leave the link up and
+// are applied without a slot.
+func (o *Optimizer) change(r *rollout, n *nic.NIC) (bool, error) {
+	if err := r.halted(); err != nil {
+		return false, fmt.Errorf("skipped: %v", err)
+	}
+	if !r.disruptive(n) {
+		return r.apply(r.ctx, n)
+	}
+
+	if r.disruptions != nil {
+		select {
+		case r.disruptions <- struct{}{}:
+		case <-r.ctx.Done():
+			return false, fmt.Errorf("skipped: %v", r.ctx.Err())
+		}
+		defer func() { <-r.disruptions }()
+
+		// Another NIC may have failed to come back while this one waited
+		if err := r.halted(); err != nil {
+			return false, fmt.Errorf("skipped: %v", err)
+		}
+	}
+
+	// A link that was already down has nothing to come back to
+	wasUp := n.OperState == "up" && n.Carrier
+	changed, err := r.apply(r.ctx, n)
+	if !changed || !wasUp {
+		return changed, err
+	}
+
+	if werr := o.waitForLink(r.ctx, n); werr != nil {
+		r.halt(werr)
+		return changed, errors.Join(err, werr)
+	}
+	return changed, err
+}
+
+// waitForLink waits up to -link-timeout until a changed NIC is back in
+// service, then for the -settle delay
+func (o *Optimizer) waitForLink(ctx context.Context, n *nic.NIC) error {
+	if n.Bond != nil {
+		o.log.Info("Waiting for %s to come back and rejoin %s %s", n.Name, n.Bond.Type, n.Bond.Master)
+	} else {
+		o.log.Info("Waiting for the link of %s to come back", n.Name)
+	}
+
+	// No timeout waits until the NIC is back or the run is interrupted
+	var deadline <-chan time.Time
+	timeout := time.Duration(o.cfg.LinkTimeout) * time.Second
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	poll := time.NewTicker(linkPollInterval)
+	defer poll.Stop()
+
+	// The link may take a moment to go down after the change, so it is not
+	// polled right away
+	for {
+		select {
+		case <-poll.C:
+		case <-deadline:
+			return fmt.Errorf("%s did not come back within %s", n.Name, timeout)
+		case <-ctx.Done():
+			return fmt.Errorf("interrupted while waiting for %s to come back", n.Name)
+		}
+		if o.isBack(n) {
+			break
+		}
+	}
+
+	settle := time.Duration(o.cfg.SettleDelay) * time.Second
+	if settle <= 0 {
+		o.log.Info("%s is back", n.Name)
+		return nil
+	}
+	o.log.Info("%s is back, settling for %s", n.Name, settle)
+
+	timer := time.NewTimer(settle)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("interrupted while %s was settling", n.Name)
+	}
+}
+
+// isBack reports whether a changed NIC has its carrier, is operationally up
+// and, for a bond or team slave, is back in its master
+func (o *Optimizer) isBack(n *nic.NIC) bool {
+	operState, carrier := o.nicMgr.GetLinkState(n.Name)
+	if operState != "up" || !carrier {
+		return false
+	}
+	return n.Bond == nil || o.nicMgr.IsSlaveUp(n.Name)
+}
+
+// halt stops any further NIC from being changed in this run
+func (r *rollout) halt(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.haltErr == nil {
+		r.haltErr = fmt.Errorf("rollout halted: %v", err)
+	}
+}
+
+// halted returns why the run was halted, if it was
+func (r *rollout) halted() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.haltErr
+}
@@ -0,0 +1,196 @@
+package ringbuffer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"optimize-hpc-nic/internal/config"
+	"optimize-hpc-nic/internal/logger"
+	"optimize-hpc-nic/internal/nic"
+	"optimize-hpc-nic/pkg/system"
+)
+
+// newTestOptimizer returns an Optimizer reading sysfs under root
+func newTestOptimizer(t *testing.T, cfg *config.Config, root string) *Optimizer {
+	t.Helper()
+	dir := t.TempDir()
+	cfg.Root = root
+	cfg.Backend = system.BackendExec
+	if cfg.MaxWorkers == 0 {
+		cfg.MaxWorkers = 1
+	}
+	log := logger.New(filepath.Join(dir, "test.log"), 1, 1, 1, false)
+	mgr, err := nic.NewManager(cfg, log)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return New(mgr, log, cfg)
+}
+
+// writeLinkState writes the operstate and carrier of a NIC under root
+func writeLinkState(t *testing.T, root, name, operState, carrier string) {
+	t.Helper()
+	dir := filepath.Join(root, "sys/class/net", name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, value := range map[string]string{"operstate": operState, "carrier": carrier} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRolloutDisruptionLimit(t *testing.T) {
+	o := newTestOptimizer(t, &config.Config{MaxDisruptions: 1}, t.TempDir())
+
+	// eth0 holds the only slot until it is released
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var applied []string
+	apply := func(ctx context.Context, n *nic.NIC) (bool, error) {
+		mu.Lock()
+		applied = append(applied, n.Name)
+		mu.Unlock()
+		if n.Name == "eth0" {
+			close(started)
+			<-release
+		}
+		return true, nil
+	}
+	disruptive := func(n *nic.NIC) bool { return n.Name != "eth2" }
+	r := o.newRollout(context.Background(), disruptive, apply)
+
+	// Links that are down are not waited for
+	done := make(chan string, 3)
+	change := func(name string) {
+		if _, err := o.change(r, &nic.NIC{Name: name, OperState: "down"}); err != nil {
+			t.Errorf("change(%s): %v", name, err)
+		}
+		done <- name
+	}
+	go change("eth0")
+	<-started
+	go change("eth1")
+	go change("eth2")
+
+	// A change that does not drop the link does not wait for a slot
+	if name := <-done; name != "eth2" {
+		t.Fatalf("%s finished while eth0 held the slot", name)
+	}
+	select {
+	case name := <-done:
+		t.Fatalf("%s finished while eth0 held the slot", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	<-done
+	<-done
+	if len(applied) != 3 || applied[2] != "eth1" {
+		t.Errorf("applied %v, want eth1 last", applied)
+	}
+}
+
+func TestRolloutHalt(t *testing.T) {
+	root := t.TempDir()
+	writeLinkState(t, root, "eth0", "down", "0")
+	o := newTestOptimizer(t, &config.Config{MaxDisruptions: 1, LinkTimeout: 1}, root)
+
+	var applied []string
+	apply := func(ctx context.Context, n *nic.NIC) (bool, error) {
+		applied = append(applied, n.Name)
+		return true, nil
+	}
+	r := o.newRollout(context.Background(), func(*nic.NIC) bool { return true }, apply)
+
+	// eth0 does not come back after the change
+	changed, err := o.change(r, &nic.NIC{Name: "eth0", OperState: "up", Carrier: true})
+	if !changed || err == nil || !strings.Contains(err.Error(), "eth0 did not come back within 1s") {
+		t.Fatalf("change(eth0) = %v, %v", changed, err)
+	}
+
+	// No further NIC is changed in this run
+	changed, err = o.change(r, &nic.NIC{Name: "eth1", OperState: "up", Carrier: true})
+	if changed || err == nil || !strings.HasPrefix(err.Error(), "skipped: rollout halted") {
+		t.Errorf("change(eth1) = %v, %v", changed, err)
+	}
+	if len(applied) != 1 {
+		t.Errorf("applied %v, want only eth0", applied)
+	}
+}
+
+func TestRolloutLinkBack(t *testing.T) {
+	root := t.TempDir()
+	writeLinkState(t, root, "eth0", "up", "1")
+	o := newTestOptimizer(t, &config.Config{MaxDisruptions: 1, LinkTimeout: 5}, root)
+
+	apply := func(ctx context.Context, n *nic.NIC) (bool, error) { return true, nil }
+	r := o.newRollout(context.Background(), func(*nic.NIC) bool { return true }, apply)
+
+	n := &nic.NIC{Name: "eth0", OperState: "up", Carrier: true}
+	if changed, err := o.change(r, n); !changed || err != nil {
+		t.Fatalf("change(eth0) = %v, %v", changed, err)
+	}
+	if err := r.halted(); err != nil {
+		t.Errorf("rollout halted: %v", err)
+	}
+}
+
+func TestHasDisruptiveChanges(t *testing.T) {
+	on := true
+	tests := []struct {
+		name   string
+		policy config.Policy
+		nic    nic.NIC
+		want   bool
+	}{
+		{
+			name: "rings",
+			nic: nic.NIC{LinkType: NICTypeEthernet, Ring: system.RingParams{RX: 1024, TX: 8192},
+				RingMax: system.RingParams{RX: 8192, TX: 8192}, RingTarget: system.RingParams{RX: 8192, TX: 8192}},
+			want: true,
+		},
+		{
+			name:   "channels",
+			policy: config.Policy{Channels: config.ChannelPolicy{Policy: config.ChannelPolicyMax}},
+			nic:    nic.NIC{LinkType: NICTypeEthernet, Channels: system.Channels{Combined: 8}, ChannelsMax: system.Channels{Combined: 63}},
+			want:   true,
+		},
+		{
+			name:   "fec",
+			policy: config.Policy{FEC: map[int]string{400000: "rs"}},
+			nic:    nic.NIC{LinkType: NICTypeEthernet, Speed: 400000, FEC: system.FECParams{Configured: []string{"Off"}}},
+			want:   true,
+		},
+		{
+			// Pause frames do not drop the link
+			name:   "pause",
+			policy: config.Policy{Pause: map[string]config.PauseTarget{"*": {RX: &on}}},
+			nic:    nic.NIC{Name: "eth0", LinkType: NICTypeEthernet, Pause: system.PauseParams{RX: "off"}},
+			want:   false,
+		},
+		{
+			// Channels of IPoIB interfaces are never changed
+			name:   "infiniband channels",
+			policy: config.Policy{Channels: config.ChannelPolicy{Policy: config.ChannelPolicyMax}},
+			nic:    nic.NIC{LinkType: NICTypeInfiniband, Channels: system.Channels{Combined: 8}, ChannelsMax: system.Channels{Combined: 63}},
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newTestOptimizer(t, &config.Config{Policy: tt.policy}, t.TempDir())
+			if got := o.hasDisruptiveChanges(&tt.nic); got != tt.want {
+				t.Errorf("hasDisruptiveChanges = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
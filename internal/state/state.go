@@ -0,0 +1,153 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"optimize-hpc-nic/pkg/system"
+)
+
+// Entry holds the settings of a NIC as they were before the first change
+type Entry struct {
+	Name      string                    `json:"name"` // interface name when recorded
+	Driver    string                    `json:"driver,omitempty"`
+	PCI       string                    `json:"pci,omitempty"`
+	MAC       string                    `json:"mac,omitempty"`
+	Recorded  time.Time                 `json:"recorded"`
+	Ring      system.RingParams         `json:"ring"`
+	Channels  system.Channels           `json:"channels"`
+	Coalesce  system.Coalesce           `json:"coalesce"`
+	Features  map[string]system.Feature `json:"features,omitempty"`
+	Pause     system.PauseParams        `json:"pause"`
+	PrivFlags map[string]bool           `json:"priv_flags,omitempty"`
+	FEC       []string                  `json:"fec,omitempty"` // configured encodings
+	IPoIBMode string                    `json:"ipoib_mode,omitempty"`
+}
+
+// Key identifies a NIC across reboots and interface renames
+func Key(pci, mac string) string {
+	return pci + "/" + strings.ToLower(mac)
+}
+
+// Store keeps the original settings of every changed NIC in a JSON file
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]Entry
+}
+
+// Load reads the state file; a missing file is an empty store
+func Load(path string) (*Store, error) {
+	s := &Store{path: path, entries: make(map[string]Entry)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Path returns the state file path
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Get returns the original settings recorded for a NIC
+func (s *Store) Get(key string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	return e, ok
+}
+
+// Entries returns a copy of every recorded entry by key
+func (s *Store) Entries() map[string]Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make(map[string]Entry, len(s.entries))
+	for key, e := range s.entries {
+		entries[key] = e
+	}
+	return entries
+}
+
+// Remember records the settings of a NIC unless it already has an entry,
+// so the entry always holds the settings from before the first change. The
+// file is written before Remember returns.
+func (s *Store) Remember(key string, e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[key]; ok {
+		return nil
+	}
+	s.entries[key] = e
+	if err := s.save(); err != nil {
+		delete(s.entries, key)
+		return err
+	}
+	return nil
+}
+
+// Forget drops the entry of a restored NIC. The file is removed once no
+// entries are left.
+func (s *Store) Forget(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[key]; !ok {
+		return nil
+	}
+	delete(s.entries, key)
+	if len(s.entries) == 0 {
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return s.save()
+}
+
+// CheckWritable reports whether a state file can be written at path,
+// creating its directory if needed
+func CheckWritable(path string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".state-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// save writes the entries through a temporary file so a crash never leaves
+// a truncated state file behind
+func (s *Store) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
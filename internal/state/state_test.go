@@ -0,0 +1,134 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"optimize-hpc-nic/pkg/system"
+)
+
+func TestKey(t *testing.T) {
+	if got := Key("0000:3b:00.0", "B8:CE:F6:01:02:03"); got != "0000:3b:00.0/b8:ce:f6:01:02:03" {
+		t.Errorf("Key = %q", got)
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "original.json")
+
+	// A missing file is an empty store
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(s.Entries()) != 0 {
+		t.Fatalf("new store has entries %v", s.Entries())
+	}
+
+	key := Key("0000:3b:00.0", "b8:ce:f6:01:02:03")
+	orig := Entry{
+		Name:      "eth0",
+		Driver:    "mlx5_core",
+		PCI:       "0000:3b:00.0",
+		MAC:       "b8:ce:f6:01:02:03",
+		Recorded:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Ring:      system.RingParams{RX: 1024, TX: 1024},
+		Channels:  system.Channels{Combined: 8},
+		Coalesce:  system.Coalesce{AdaptiveRX: "on", Params: map[string]int{"rx-usecs": 8}},
+		Features:  map[string]system.Feature{"generic-receive-offload": {Enabled: false}},
+		Pause:     system.PauseParams{RX: "off", TX: "on"},
+		PrivFlags: map[string]bool{"rx_cqe_compress": false},
+		FEC:       []string{"Auto", "RS"},
+	}
+	if err := s.Remember(key, orig); err != nil {
+		t.Fatalf("Remember: %v", err)
+	}
+
+	// A later change never replaces the settings from before the first one
+	later := orig
+	later.Ring = system.RingParams{RX: 8192, TX: 8192}
+	if err := s.Remember(key, later); err != nil {
+		t.Fatalf("Remember: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	got, ok := loaded.Get(key)
+	if !ok {
+		t.Fatalf("%s not saved", key)
+	}
+	if !reflect.DeepEqual(got, orig) {
+		t.Errorf("loaded entry =\n%+v\nwant\n%+v", got, orig)
+	}
+
+	// The file is kept while entries are left and removed with the last one
+	other := Key("0000:3b:00.1", "b8:ce:f6:01:02:04")
+	if err := loaded.Remember(other, Entry{Name: "ib0", IPoIBMode: "datagram"}); err != nil {
+		t.Fatalf("Remember: %v", err)
+	}
+	if err := loaded.Forget(key); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if reloaded, err := Load(path); err != nil || len(reloaded.Entries()) != 1 {
+		t.Fatalf("after Forget the file holds %v, %v", reloaded.Entries(), err)
+	}
+	if err := loaded.Forget(key); err != nil {
+		t.Errorf("Forget of a missing entry: %v", err)
+	}
+	if err := loaded.Forget(other); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("state file left after the last Forget: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestRememberSaveError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	s, err := Load(filepath.Join(dir, "original.json"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// The state file's directory turns out to be a regular file
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remember("key", Entry{Name: "eth0"}); err == nil {
+		t.Fatal("Remember succeeded without a writable file")
+	}
+	if _, ok := s.Get("key"); ok {
+		t.Error("entry kept although it was not saved")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "original.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "invalid state file") {
+		t.Errorf("Load = %v, want an invalid state file error", err)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	if err := CheckWritable(filepath.Join(dir, "original.json")); err != nil {
+		t.Fatalf("CheckWritable: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("CheckWritable left %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,198 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"optimize-hpc-nic/pkg/system"
+)
+
+// Counter sources
+const (
+	SourceDriver = "driver" // ethtool -S
+	SourceKernel = "kernel" // /sys/class/net/<if>/statistics
+)
+
+// WatchedCounters are the counters that indicate drops or stalls
+var WatchedCounters = []string{
+	"rx_out_of_buffer",
+	"rx_discards_phy",
+	"rx_buffer_passed_thres_phy",
+	"tx_timeout",
+	"tx_discards_phy",
+	"rx_dropped",
+	"tx_dropped",
+	"rx_missed_errors",
+	"rx_fifo_errors",
+	"rx_errors",
+	"tx_errors",
+}
+
+// Sample is a snapshot of a NIC's counters
+type Sample struct {
+	Time   time.Time
+	Driver map[string]uint64
+	Kernel map[string]uint64
+}
+
+// Delta is the change of a counter between two samples
+type Delta struct {
+	Name   string
+	Source string
+	Value  uint64  // latest value
+	Delta  uint64  // change since the previous sample
+	Rate   float64 // change per second
+}
+
+// Collector reads NIC counters and keeps the previous sample of every
+// interface so per-interval deltas can be computed
+type Collector struct {
+	mu       sync.Mutex
+	ethtool  *system.Ethtool
+	fs       *system.FS
+	previous map[string]*Sample
+}
+
+// NewCollector creates a new Collector
+func NewCollector(ethtool *system.Ethtool, fs *system.FS) *Collector {
+	return &Collector{
+		ethtool:  ethtool,
+		fs:       fs,
+		previous: make(map[string]*Sample),
+	}
+}
+
+// Read takes a sample of a NIC's counters without recording it
+func (c *Collector) Read(ctx context.Context, name string) (*Sample, error) {
+	sample := &Sample{Time: time.Now()}
+
+	driver, driverErr := c.ethtool.GetStats(ctx, name)
+	if driverErr == nil {
+		sample.Driver = driver
+	}
+	kernel, kernelErr := readKernelStats(c.fs, name)
+	if kernelErr == nil {
+		sample.Kernel = kernel
+	}
+
+	if driverErr != nil && kernelErr != nil {
+		return nil, fmt.Errorf("failed to read statistics for %s: %v; %v", name, driverErr, kernelErr)
+	}
+	return sample, nil
+}
+
+// Collect takes a sample of a NIC's counters, computes the deltas against
+// the previous sample and records it as the new previous sample. The
+// first sample of an interface yields deltas of zero.
+func (c *Collector) Collect(ctx context.Context, name string) ([]Delta, error) {
+	sample, err := c.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deltas := Diff(c.previous[name], sample)
+	c.previous[name] = sample
+
+	return deltas, nil
+}
+
+// Diff computes the deltas of every counter in cur against prev, sorted by
+// source and name. A counter that went backwards is treated as reset.
+func Diff(prev, cur *Sample) []Delta {
+	var deltas []Delta
+
+	var interval float64
+	if prev != nil {
+		interval = cur.Time.Sub(prev.Time).Seconds()
+	}
+
+	for _, src := range []struct {
+		source string
+		cur    map[string]uint64
+		prev   map[string]uint64
+	}{
+		{SourceDriver, cur.Driver, previousCounters(prev, SourceDriver)},
+		{SourceKernel, cur.Kernel, previousCounters(prev, SourceKernel)},
+	} {
+		for name, value := range src.cur {
+			d := Delta{Name: name, Source: src.source, Value: value}
+			if old, ok := src.prev[name]; ok {
+				if value >= old {
+					d.Delta = value - old
+				} else {
+					d.Delta = value
+				}
+				if interval > 0 {
+					d.Rate = float64(d.Delta) / interval
+				}
+			}
+			deltas = append(deltas, d)
+		}
+	}
+
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].Source != deltas[j].Source {
+			return deltas[i].Source < deltas[j].Source
+		}
+		return deltas[i].Name < deltas[j].Name
+	})
+	return deltas
+}
+
+// Watched filters deltas down to the WatchedCounters
+func Watched(deltas []Delta) []Delta {
+	watched := make(map[string]bool, len(WatchedCounters))
+	for _, name := range WatchedCounters {
+		watched[name] = true
+	}
+
+	var result []Delta
+	for _, d := range deltas {
+		if watched[d.Name] {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+func previousCounters(prev *Sample, source string) map[string]uint64 {
+	if prev == nil {
+		return nil
+	}
+	if source == SourceDriver {
+		return prev.Driver
+	}
+	return prev.Kernel
+}
+
+// readKernelStats reads /sys/class/net/<name>/statistics
+func readKernelStats(fs *system.FS, name string) (map[string]uint64, error) {
+	dir := fmt.Sprintf("/sys/class/net/%s/statistics", name)
+	files, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make(map[string]uint64)
+	for _, file := range files {
+		data, err := fs.ReadFile(path.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err == nil {
+			counters[file.Name()] = value
+		}
+	}
+
+	return counters, nil
+}
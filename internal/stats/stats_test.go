@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	prev := &Sample{
+		Time:   start,
+		Driver: map[string]uint64{"rx_out_of_buffer": 100, "tx_timeout": 5},
+		Kernel: map[string]uint64{"rx_dropped": 10},
+	}
+
+	tests := []struct {
+		name string
+		prev *Sample
+		cur  *Sample
+		want []Delta
+	}{
+		{
+			name: "first sample",
+			cur: &Sample{
+				Time:   start,
+				Driver: map[string]uint64{"rx_out_of_buffer": 100},
+			},
+			want: []Delta{{Name: "rx_out_of_buffer", Source: SourceDriver, Value: 100}},
+		},
+		{
+			name: "increase, reset and new counter",
+			prev: prev,
+			cur: &Sample{
+				Time:   start.Add(10 * time.Second),
+				Driver: map[string]uint64{"tx_timeout": 2, "rx_out_of_buffer": 150, "rx_discards_phy": 7},
+				Kernel: map[string]uint64{"rx_dropped": 10},
+			},
+			want: []Delta{
+				{Name: "rx_discards_phy", Source: SourceDriver, Value: 7},
+				{Name: "rx_out_of_buffer", Source: SourceDriver, Value: 150, Delta: 50, Rate: 5},
+				{Name: "tx_timeout", Source: SourceDriver, Value: 2, Delta: 2, Rate: 0.2}, // reset
+				{Name: "rx_dropped", Source: SourceKernel, Value: 10},
+			},
+		},
+		{
+			name: "no interval",
+			prev: prev,
+			cur: &Sample{
+				Time:   start,
+				Driver: map[string]uint64{"rx_out_of_buffer": 110},
+			},
+			want: []Delta{{Name: "rx_out_of_buffer", Source: SourceDriver, Value: 110, Delta: 10}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.prev, tt.cur); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatched(t *testing.T) {
+	deltas := []Delta{
+		{Name: "rx_out_of_buffer", Source: SourceDriver},
+		{Name: "rx_packets", Source: SourceDriver},
+		{Name: "rx_dropped", Source: SourceKernel},
+	}
+	want := []Delta{deltas[0], deltas[2]}
+	if got := Watched(deltas); !reflect.DeepEqual(got, want) {
+		t.Errorf("Watched = %+v, want %+v", got, want)
+	}
+	if got := Watched(deltas[1:2]); got != nil {
+		t.Errorf("Watched = %+v, want nil", got)
+	}
+}
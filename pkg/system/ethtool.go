@@ -1,129 +1,521 @@
 package system
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"os/exec"
-	"regexp"
+	"path"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
+)
+
+// Ethtool backends
+const (
+	BackendAuto    = "auto"    // netlink when available, exec otherwise
+	BackendNetlink = "netlink" // ethtool generic netlink only
+	BackendExec    = "exec"    // fork the ethtool binary and parse its output
 )
 
 // Ethtool provides access to ethtool functionality
-type Ethtool struct{}
+type Ethtool struct {
+	runner   Runner
+	nl       *netlinkClient
+	fallback bool // fall back to exec when netlink does not support a request
+}
 
-// NewEthtool creates a new Ethtool
+// NewEthtool creates a new Ethtool using the exec backend
 func NewEthtool() *Ethtool {
-	return &Ethtool{}
+	return &Ethtool{runner: ExecRunner{}}
+}
+
+// NewEthtoolBackend creates a new Ethtool using the given backend. The
+// runner is used by the exec backend and for netlink fallbacks. A netlink
+// request or driver info ioctl not finished within timeout (0 = no
+// timeout), or when its ctx is done, is abandoned: the call returns the
+// context error while a request stuck in the driver finishes on its own.
+func NewEthtoolBackend(backend string, runner Runner, timeout time.Duration) (*Ethtool, error) {
+	switch backend {
+	case BackendExec:
+		return &Ethtool{runner: runner}, nil
+	case BackendNetlink, BackendAuto:
+		nl, err := newNetlinkClient(timeout)
+		if err != nil {
+			if backend == BackendAuto {
+				return &Ethtool{runner: runner}, nil
+			}
+			return nil, fmt.Errorf("ethtool netlink backend unavailable: %v", err)
+		}
+		return &Ethtool{runner: runner, nl: nl, fallback: backend == BackendAuto}, nil
+	default:
+		return nil, fmt.Errorf("unknown ethtool backend: %s", backend)
+	}
+}
+
+// Backend returns the name of the backend in use
+func (e *Ethtool) Backend() string {
+	if e.nl != nil {
+		return BackendNetlink
+	}
+	return BackendExec
+}
+
+// useExec reports whether a call should go to the exec backend after
+// the netlink attempt returned err. Only requests netlink cannot serve fall
+// back; a setting the kernel rejected is not sent a second time.
+func (e *Ethtool) useExec(err error) bool {
+	return e.nl == nil || (e.fallback && netlinkUnsupported(err))
+}
+
+// netlinkUnsupported reports whether a netlink request failed because the
+// kernel or driver does not implement the message
+func netlinkUnsupported(err error) bool {
+	return errors.Is(err, syscall.EOPNOTSUPP)
+}
+
+// DriverInfo holds the driver and firmware information of ethtool -i
+type DriverInfo struct {
+	Driver              string
+	Version             string
+	FirmwareVersion     string
+	ExpansionROMVersion string
+	BusInfo             string
 }
 
 // GetDriverInfo returns the driver info for a network interface
-func (e *Ethtool) GetDriverInfo(name string) (string, error) {
-	cmd := exec.Command("ethtool", "-i", name)
-	output, err := cmd.CombinedOutput()
+func (e *Ethtool) GetDriverInfo(ctx context.Context, name string) (DriverInfo, error) {
+	var err error
+	if e.nl != nil {
+		var info DriverInfo
+		if info, err = e.nl.driverInfo(ctx, name); err == nil {
+			return info, nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execGetDriverInfo(ctx, name)
+	}
+	return DriverInfo{}, err
+}
+
+// LinkSpeed holds the speeds of a network interface in Mbps, zero when
+// unknown
+type LinkSpeed struct {
+	Current   int // negotiated speed of an up link
+	Supported int // highest supported link mode, known even when the link is down
+}
+
+// GetLinkSpeed returns the negotiated and the highest supported speed of a
+// network interface, both from a single ethtool query
+func (e *Ethtool) GetLinkSpeed(ctx context.Context, name string) (LinkSpeed, error) {
+	var err error
+	if e.nl != nil {
+		var speed LinkSpeed
+		if speed, err = e.nl.getLinkSpeed(ctx, name); err == nil {
+			return speed, nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execGetLinkSpeed(ctx, name)
+	}
+	return LinkSpeed{}, err
+}
+
+// linkModeSpeed returns the speed in Mbps of a link mode name such as
+// 400000baseCR4/Full, or 0 if it has none
+func linkModeSpeed(mode string) int {
+	i := strings.Index(mode, "base")
+	if i <= 0 {
+		return 0
+	}
+	speed, err := strconv.Atoi(mode[:i])
 	if err != nil {
-		return "", err
+		return 0
 	}
+	return speed
+}
 
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "driver:") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				return strings.TrimSpace(parts[1]), nil
-			}
+// RingParams holds the ring parameters reported by ethtool -g. Zero
+// sizes and empty strings mean the driver does not report the parameter.
+type RingParams struct {
+	RX           int
+	RXMini       int
+	RXJumbo      int
+	TX           int
+	RXBufLen     int
+	CQESize      int
+	TXPush       string // on, off
+	RXPush       string // on, off
+	TCPDataSplit string // on, off, auto
+}
+
+// Merge returns p with every parameter set in other applied on top
+func (p RingParams) Merge(other RingParams) RingParams {
+	for _, f := range []struct{ dst, src *int }{
+		{&p.RX, &other.RX},
+		{&p.RXMini, &other.RXMini},
+		{&p.RXJumbo, &other.RXJumbo},
+		{&p.TX, &other.TX},
+		{&p.RXBufLen, &other.RXBufLen},
+		{&p.CQESize, &other.CQESize},
+	} {
+		if *f.src > 0 {
+			*f.dst = *f.src
+		}
+	}
+	for _, f := range []struct{ dst, src *string }{
+		{&p.TXPush, &other.TXPush},
+		{&p.RXPush, &other.RXPush},
+		{&p.TCPDataSplit, &other.TCPDataSplit},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
 		}
 	}
+	return p
+}
 
-	return "", fmt.Errorf("driver not found for %s", name)
+// GetRingBufferSettings returns the current and maximum ring parameters
+func (e *Ethtool) GetRingBufferSettings(ctx context.Context, name string) (cur, max RingParams, err error) {
+	if e.nl != nil {
+		if cur, max, err = e.nl.getRings(ctx, name); err == nil {
+			return cur, max, nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execGetRingBufferSettings(ctx, name)
+	}
+	return RingParams{}, RingParams{}, err
 }
 
-// GetSpeed returns the speed of a network interface in Mbps
-func (e *Ethtool) GetSpeed(name string) (int, error) {
-	cmd := exec.Command("ethtool", name)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return 0, err
-	}
-
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "Speed:") {
-			// Extract the numeric part
-			re := regexp.MustCompile(`(\d+)`)
-			matches := re.FindStringSubmatch(line)
-			if len(matches) > 1 {
-				speed, err := strconv.Atoi(matches[1])
-				if err == nil {
-					return speed, nil
-				}
-			}
+// SetRingBuffer sets the RX/TX ring sizes for a network interface
+func (e *Ethtool) SetRingBuffer(ctx context.Context, name string, rx, tx int) error {
+	return e.SetRingParams(ctx, name, RingParams{RX: rx, TX: tx})
+}
+
+// SetRingParams sets the ring parameters for a network interface. Zero
+// sizes and empty strings leave the parameter unchanged.
+func (e *Ethtool) SetRingParams(ctx context.Context, name string, p RingParams) error {
+	var err error
+	if e.nl != nil {
+		if err = e.nl.setRings(ctx, name, p); err == nil {
+			return nil
 		}
 	}
+	if e.useExec(err) {
+		return e.execSetRingParams(ctx, name, p)
+	}
+	return fmt.Errorf("failed to set ring buffer: %w", err)
+}
 
-	return 0, fmt.Errorf("speed not found for %s", name)
+// IsBusy reports whether a set failed with EBUSY, e.g. while the driver is
+// still reconfiguring after a previous change. Such failures are usually
+// transient. Errors from the exec backend only carry the message.
+func IsBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, syscall.EBUSY) ||
+		strings.Contains(strings.ToLower(err.Error()), strings.ToLower(syscall.EBUSY.Error()))
 }
 
-// GetRingBufferSettings returns the current and maximum ring buffer settings
-func (e *Ethtool) GetRingBufferSettings(name string) (rxCur, txCur, rxMax, txMax int, err error) {
-	cmd := exec.Command("ethtool", "-g", name)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return 0, 0, 0, 0, err
+// Channels holds the queue counts reported by ethtool -l. Zero means the
+// driver does not report that channel type.
+type Channels struct {
+	RX       int
+	TX       int
+	Other    int
+	Combined int
+}
+
+// GetChannels returns the current and maximum channel counts
+func (e *Ethtool) GetChannels(ctx context.Context, name string) (cur, max Channels, err error) {
+	if e.nl != nil {
+		if cur, max, err = e.nl.getChannels(ctx, name); err == nil {
+			return cur, max, nil
+		}
 	}
+	if e.useExec(err) {
+		return e.execGetChannels(ctx, name)
+	}
+	return Channels{}, Channels{}, err
+}
 
-	// Parse the output
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	inPreset := false
-	inCurrent := false
+// SetChannels sets the channel counts for a network interface. Zero
+// counts leave the channel type unchanged.
+func (e *Ethtool) SetChannels(ctx context.Context, name string, c Channels) error {
+	var err error
+	if e.nl != nil {
+		if err = e.nl.setChannels(ctx, name, c); err == nil {
+			return nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execSetChannels(ctx, name, c)
+	}
+	return fmt.Errorf("failed to set channels: %w", err)
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+// Coalesce holds interrupt coalescing parameters. Params is keyed by the
+// ethtool parameter name (rx-usecs, tx-frames, ...) and only contains the
+// parameters the driver supports.
+type Coalesce struct {
+	AdaptiveRX string // on, off
+	AdaptiveTX string // on, off
+	Params     map[string]int
+}
 
-		if strings.Contains(line, "Pre-set maximums:") {
-			inPreset = true
-			inCurrent = false
-			continue
-		} else if strings.Contains(line, "Current hardware settings:") {
-			inPreset = false
-			inCurrent = true
-			continue
+// GetCoalesce returns the interrupt coalescing settings
+func (e *Ethtool) GetCoalesce(ctx context.Context, name string) (Coalesce, error) {
+	var err error
+	if e.nl != nil {
+		var c Coalesce
+		if c, err = e.nl.getCoalesce(ctx, name); err == nil {
+			return c, nil
 		}
+	}
+	if e.useExec(err) {
+		return e.execGetCoalesce(ctx, name)
+	}
+	return Coalesce{}, err
+}
 
-		if inPreset && strings.HasPrefix(line, "RX:") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				rxMax, _ = strconv.Atoi(parts[1])
-			}
-		} else if inPreset && strings.HasPrefix(line, "TX:") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				txMax, _ = strconv.Atoi(parts[1])
-			}
-		} else if inCurrent && strings.HasPrefix(line, "RX:") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				rxCur, _ = strconv.Atoi(parts[1])
+// SetCoalesce sets the interrupt coalescing parameters present in c
+func (e *Ethtool) SetCoalesce(ctx context.Context, name string, c Coalesce) error {
+	var err error
+	if e.nl != nil {
+		if err = e.nl.setCoalesce(ctx, name, c); err == nil {
+			return nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execSetCoalesce(ctx, name, c)
+	}
+	return fmt.Errorf("failed to set coalesce parameters: %w", err)
+}
+
+// Feature is the state of an offload feature reported by ethtool -k
+type Feature struct {
+	Enabled bool
+	Fixed   bool // cannot be changed
+}
+
+// featureAliases maps the short names accepted by ethtool -K to the
+// names printed by ethtool -k
+var featureAliases = map[string]string{
+	"rx":     "rx-checksumming",
+	"tx":     "tx-checksumming",
+	"sg":     "scatter-gather",
+	"tso":    "tcp-segmentation-offload",
+	"gso":    "generic-segmentation-offload",
+	"gro":    "generic-receive-offload",
+	"lro":    "large-receive-offload",
+	"rxvlan": "rx-vlan-offload",
+	"txvlan": "tx-vlan-offload",
+	"ntuple": "ntuple-filters",
+	"rxhash": "receive-hashing",
+}
+
+// legacyFeatures maps the names ethtool -k prints for groups of kernel
+// features to a pattern matching the kernel names of the group, as in
+// ethtool's off_flag_def
+var legacyFeatures = []struct{ name, pattern string }{
+	{"rx-checksumming", "rx-checksum"},
+	{"tx-checksumming", "tx-checksum-*"},
+	{"scatter-gather", "tx-scatter-gather*"},
+	{"tcp-segmentation-offload", "tx-tcp*-segmentation"},
+	{"generic-segmentation-offload", "tx-generic-segmentation"},
+	{"generic-receive-offload", "rx-gro"},
+	{"large-receive-offload", "rx-lro"},
+	{"rx-vlan-offload", "rx-vlan-hw-parse"},
+	{"tx-vlan-offload", "tx-vlan-hw-insert"},
+	{"ntuple-filters", "rx-ntuple-filter"},
+	{"receive-hashing", "rx-hashing"},
+}
+
+// FeatureGroupExpanded reports whether name is the ethtool -k name of a
+// group of kernel features, such as tcp-segmentation-offload, whose
+// features are also listed in features. Changing the group changes every
+// feature in it, so those features are the ones to compare and set one by
+// one. ethtool -k does not list the feature of a group of one, e.g. rx-gro
+// under generic-receive-offload.
+func FeatureGroupExpanded(features map[string]Feature, name string) bool {
+	for _, legacy := range legacyFeatures {
+		if legacy.name != name {
+			continue
+		}
+		for feature := range features {
+			if ok, _ := path.Match(legacy.pattern, feature); ok {
+				return true
 			}
-		} else if inCurrent && strings.HasPrefix(line, "TX:") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				txCur, _ = strconv.Atoi(parts[1])
+		}
+	}
+	return false
+}
+
+// FeatureName returns the ethtool -k name of a feature given either its
+// full name or a short ethtool -K alias such as gro
+func FeatureName(name string) string {
+	if full, ok := featureAliases[name]; ok {
+		return full
+	}
+	return name
+}
+
+// GetFeatures returns the offload features of a network interface
+func (e *Ethtool) GetFeatures(ctx context.Context, name string) (map[string]Feature, error) {
+	var err error
+	if e.nl != nil {
+		var features map[string]Feature
+		if features, err = e.nl.getFeatures(ctx, name); err == nil {
+			return features, nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execGetFeatures(ctx, name)
+	}
+	return nil, err
+}
+
+// SetFeatures enables or disables offload features
+func (e *Ethtool) SetFeatures(ctx context.Context, name string, features map[string]bool) error {
+	var err error
+	if e.nl != nil {
+		if err = e.nl.setFeatures(ctx, name, features); err == nil {
+			return nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execSetFeatures(ctx, name, features)
+	}
+	return fmt.Errorf("failed to set features: %w", err)
+}
+
+// PauseParams holds the flow-control settings reported by ethtool -a.
+// Values are "on" or "off"; empty means not reported.
+type PauseParams struct {
+	Autoneg string
+	RX      string
+	TX      string
+}
+
+// GetPause returns the pause frame settings
+func (e *Ethtool) GetPause(ctx context.Context, name string) (PauseParams, error) {
+	var err error
+	if e.nl != nil {
+		var p PauseParams
+		if p, err = e.nl.getPause(ctx, name); err == nil {
+			return p, nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execGetPause(ctx, name)
+	}
+	return PauseParams{}, err
+}
+
+// SetPause sets the pause frame settings present in p
+func (e *Ethtool) SetPause(ctx context.Context, name string, p PauseParams) error {
+	var err error
+	if e.nl != nil {
+		if err = e.nl.setPause(ctx, name, p); err == nil {
+			return nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execSetPause(ctx, name, p)
+	}
+	return fmt.Errorf("failed to set pause parameters: %w", err)
+}
+
+// GetPrivFlags returns the driver private flags of a network interface
+func (e *Ethtool) GetPrivFlags(ctx context.Context, name string) (map[string]bool, error) {
+	var err error
+	if e.nl != nil {
+		var flags map[string]bool
+		if flags, err = e.nl.getPrivFlags(ctx, name); err == nil {
+			return flags, nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execGetPrivFlags(ctx, name)
+	}
+	return nil, err
+}
+
+// SetPrivFlags sets driver private flags
+func (e *Ethtool) SetPrivFlags(ctx context.Context, name string, flags map[string]bool) error {
+	var err error
+	if e.nl != nil {
+		if err = e.nl.setPrivFlags(ctx, name, flags); err == nil {
+			return nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execSetPrivFlags(ctx, name, flags)
+	}
+	return fmt.Errorf("failed to set private flags: %w", err)
+}
+
+// GetStats returns the driver statistics reported by ethtool -S. Netlink
+// only carries the standard statistics groups, not the driver's own
+// counters, so this always runs ethtool, even with the netlink backend.
+func (e *Ethtool) GetStats(ctx context.Context, name string) (map[string]uint64, error) {
+	return e.execGetStats(ctx, name)
+}
+
+// FECParams holds the forward error correction settings reported by
+// ethtool --show-fec
+type FECParams struct {
+	Configured []string // e.g. Auto, RS, BaseR, Off
+	Active     string
+}
+
+// fecEncodings lists the FEC encodings as ethtool --show-fec prints them
+var fecEncodings = []string{"Auto", "Off", "RS", "BaseR", "LLRS"}
+
+// FECEncodings splits encodings given as to ethtool --set-fec, e.g.
+// "auto rs", and names them as ethtool --show-fec prints them. Unknown
+// encodings are kept as given.
+func FECEncodings(encoding string) []string {
+	var encodings []string
+	for _, enc := range strings.Fields(encoding) {
+		for _, name := range fecEncodings {
+			if strings.EqualFold(enc, name) {
+				enc = name
 			}
 		}
+		encodings = append(encodings, enc)
 	}
+	return encodings
+}
 
-	return rxCur, txCur, rxMax, txMax, nil
+// GetFEC returns the FEC settings of a network interface
+func (e *Ethtool) GetFEC(ctx context.Context, name string) (FECParams, error) {
+	var err error
+	if e.nl != nil {
+		var p FECParams
+		if p, err = e.nl.getFEC(ctx, name); err == nil {
+			return p, nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execGetFEC(ctx, name)
+	}
+	return FECParams{}, err
 }
 
-// SetRingBuffer sets the ring buffer for a network interface
-func (e *Ethtool) SetRingBuffer(name string, rx, tx int) error {
-	cmd := exec.Command("ethtool", "-G", name, "rx", fmt.Sprintf("%d", rx), "tx", fmt.Sprintf("%d", tx))
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to set ring buffer: %v, output: %s", err, output)
+// SetFEC sets the FEC encoding (auto, off, rs, baser, llrs). Several
+// encodings may be given separated by spaces, e.g. "auto rs".
+func (e *Ethtool) SetFEC(ctx context.Context, name string, encoding string) error {
+	var err error
+	if e.nl != nil {
+		if err = e.nl.setFEC(ctx, name, encoding); err == nil {
+			return nil
+		}
+	}
+	if e.useExec(err) {
+		return e.execSetFEC(ctx, name, encoding)
 	}
-	return nil
+	return fmt.Errorf("failed to set FEC: %w", err)
 }
@@ -0,0 +1,508 @@
+package system
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// execGetDriverInfo returns the driver information parsed from `ethtool -i`
+func (e *Ethtool) execGetDriverInfo(ctx context.Context, name string) (DriverInfo, error) {
+	output, err := e.runner.Run(ctx, "ethtool", "-i", name)
+	if err != nil {
+		return DriverInfo{}, err
+	}
+
+	var info DriverInfo
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch parts[0] {
+		case "driver":
+			info.Driver = value
+		case "version":
+			info.Version = value
+		case "firmware-version":
+			info.FirmwareVersion = value
+		case "expansion-rom-version":
+			info.ExpansionROMVersion = value
+		case "bus-info":
+			info.BusInfo = value
+		}
+	}
+
+	if info.Driver == "" {
+		return DriverInfo{}, fmt.Errorf("driver not found for %s", name)
+	}
+	return info, nil
+}
+
+// execGetLinkSpeed returns the speeds parsed from `ethtool <name>`
+func (e *Ethtool) execGetLinkSpeed(ctx context.Context, name string) (LinkSpeed, error) {
+	output, err := e.runner.Run(ctx, "ethtool", name)
+	if err != nil {
+		return LinkSpeed{}, err
+	}
+
+	speed := parseLinkSpeed(output)
+	if speed == (LinkSpeed{}) {
+		return LinkSpeed{}, fmt.Errorf("speed not found for %s", name)
+	}
+	return speed, nil
+}
+
+// parseLinkSpeed parses the "Speed:" line and the "Supported link modes"
+// list of `ethtool <name>`; the list continues on lines without a label.
+// A down link reports "Speed: Unknown!", leaving Current zero.
+func parseLinkSpeed(output []byte) LinkSpeed {
+	var speed LinkSpeed
+	inModes := false
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "Speed:"):
+			inModes = false
+			value := strings.TrimSpace(strings.TrimPrefix(line, "Speed:"))
+			if n, err := strconv.Atoi(strings.TrimSuffix(value, "Mb/s")); err == nil && n > 0 {
+				speed.Current = n
+			}
+			continue
+		case strings.HasPrefix(line, "Supported link modes:"):
+			inModes = true
+			line = strings.TrimPrefix(line, "Supported link modes:")
+		case strings.Contains(line, ":"):
+			inModes = false
+		}
+		if !inModes {
+			continue
+		}
+		for _, mode := range strings.Fields(line) {
+			if s := linkModeSpeed(mode); s > speed.Supported {
+				speed.Supported = s
+			}
+		}
+	}
+	return speed
+}
+
+// parseCurrentMax parses ethtool output split into "Pre-set maximums"
+// and "Current hardware settings" sections, as printed by -g and -l.
+// Parameters reported as n/a are omitted.
+func parseCurrentMax(output []byte) (cur, max map[string]string) {
+	cur = make(map[string]string)
+	max = make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	var section map[string]string
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.Contains(line, "Pre-set maximums:") {
+			section = max
+			continue
+		} else if strings.Contains(line, "Current hardware settings:") {
+			section = cur
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if section == nil || len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		if value != "n/a" {
+			section[strings.TrimSpace(parts[0])] = value
+		}
+	}
+
+	return cur, max
+}
+
+// execGetRingBufferSettings parses the output of `ethtool -g`
+func (e *Ethtool) execGetRingBufferSettings(ctx context.Context, name string) (cur, max RingParams, err error) {
+	output, err := e.runner.Run(ctx, "ethtool", "-g", name)
+	if err != nil {
+		return RingParams{}, RingParams{}, err
+	}
+
+	curValues, maxValues := parseCurrentMax(output)
+	return ringParamsFrom(curValues), ringParamsFrom(maxValues), nil
+}
+
+// ringParamsFrom converts parsed `ethtool -g` values into RingParams
+func ringParamsFrom(values map[string]string) RingParams {
+	var p RingParams
+	p.RX, _ = strconv.Atoi(values["RX"])
+	p.RXMini, _ = strconv.Atoi(values["RX Mini"])
+	p.RXJumbo, _ = strconv.Atoi(values["RX Jumbo"])
+	p.TX, _ = strconv.Atoi(values["TX"])
+	p.RXBufLen, _ = strconv.Atoi(values["RX Buf Len"])
+	p.CQESize, _ = strconv.Atoi(values["CQE Size"])
+	p.TXPush = values["TX Push"]
+	p.RXPush = values["RX Push"]
+	p.TCPDataSplit = values["TCP data split"]
+	return p
+}
+
+// execSetRingParams runs `ethtool -G` with every parameter set in p
+func (e *Ethtool) execSetRingParams(ctx context.Context, name string, p RingParams) error {
+	args := []string{"-G", name}
+	for _, opt := range []struct {
+		name  string
+		value int
+	}{
+		{"rx", p.RX},
+		{"rx-mini", p.RXMini},
+		{"rx-jumbo", p.RXJumbo},
+		{"tx", p.TX},
+		{"rx-buf-len", p.RXBufLen},
+		{"cqe-size", p.CQESize},
+	} {
+		if opt.value > 0 {
+			args = append(args, opt.name, strconv.Itoa(opt.value))
+		}
+	}
+	for _, opt := range []struct {
+		name  string
+		value string
+	}{
+		{"tx-push", p.TXPush},
+		{"rx-push", p.RXPush},
+		{"tcp-data-split", p.TCPDataSplit},
+	} {
+		if opt.value != "" {
+			args = append(args, opt.name, opt.value)
+		}
+	}
+
+	output, err := e.runner.Run(ctx, "ethtool", args...)
+	if err != nil {
+		return fmt.Errorf("failed to set ring buffer: %w, output: %s", err, output)
+	}
+	return nil
+}
+
+// execGetChannels parses the output of `ethtool -l`
+func (e *Ethtool) execGetChannels(ctx context.Context, name string) (cur, max Channels, err error) {
+	output, err := e.runner.Run(ctx, "ethtool", "-l", name)
+	if err != nil {
+		return Channels{}, Channels{}, err
+	}
+
+	curValues, maxValues := parseCurrentMax(output)
+	return channelsFrom(curValues), channelsFrom(maxValues), nil
+}
+
+// channelsFrom converts parsed `ethtool -l` values into Channels
+func channelsFrom(values map[string]string) Channels {
+	var c Channels
+	c.RX, _ = strconv.Atoi(values["RX"])
+	c.TX, _ = strconv.Atoi(values["TX"])
+	c.Other, _ = strconv.Atoi(values["Other"])
+	c.Combined, _ = strconv.Atoi(values["Combined"])
+	return c
+}
+
+// execSetChannels runs `ethtool -L` with every count set in c
+func (e *Ethtool) execSetChannels(ctx context.Context, name string, c Channels) error {
+	args := []string{"-L", name}
+	for _, opt := range []struct {
+		name  string
+		value int
+	}{
+		{"rx", c.RX},
+		{"tx", c.TX},
+		{"other", c.Other},
+		{"combined", c.Combined},
+	} {
+		if opt.value > 0 {
+			args = append(args, opt.name, strconv.Itoa(opt.value))
+		}
+	}
+
+	output, err := e.runner.Run(ctx, "ethtool", args...)
+	if err != nil {
+		return fmt.Errorf("failed to set channels: %w, output: %s", err, output)
+	}
+	return nil
+}
+
+// execGetCoalesce parses the output of `ethtool -c`
+func (e *Ethtool) execGetCoalesce(ctx context.Context, name string) (Coalesce, error) {
+	output, err := e.runner.Run(ctx, "ethtool", "-c", name)
+	if err != nil {
+		return Coalesce{}, err
+	}
+
+	c := Coalesce{Params: make(map[string]int)}
+	adaptive := regexp.MustCompile(`^Adaptive RX:\s*(\S+)\s+TX:\s*(\S+)`)
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if m := adaptive.FindStringSubmatch(line); m != nil {
+			if m[1] != "n/a" {
+				c.AdaptiveRX = m[1]
+			}
+			if m[2] != "n/a" {
+				c.AdaptiveTX = m[2]
+			}
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			continue // n/a or not a numeric parameter
+		}
+		c.Params[strings.TrimSpace(parts[0])] = value
+	}
+
+	return c, nil
+}
+
+// execSetCoalesce runs `ethtool -C` with every parameter set in c
+func (e *Ethtool) execSetCoalesce(ctx context.Context, name string, c Coalesce) error {
+	args := []string{"-C", name}
+	if c.AdaptiveRX != "" {
+		args = append(args, "adaptive-rx", c.AdaptiveRX)
+	}
+	if c.AdaptiveTX != "" {
+		args = append(args, "adaptive-tx", c.AdaptiveTX)
+	}
+
+	// Sorted so the command line is stable for fixtures
+	keys := make([]string, 0, len(c.Params))
+	for key := range c.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, key, strconv.Itoa(c.Params[key]))
+	}
+
+	output, err := e.runner.Run(ctx, "ethtool", args...)
+	if err != nil {
+		return fmt.Errorf("failed to set coalesce parameters: %w, output: %s", err, output)
+	}
+	return nil
+}
+
+// execGetFeatures parses the output of `ethtool -k`
+func (e *Ethtool) execGetFeatures(ctx context.Context, name string) (map[string]Feature, error) {
+	output, err := e.runner.Run(ctx, "ethtool", "-k", name)
+	if err != nil {
+		return nil, err
+	}
+
+	features := make(map[string]Feature)
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) == 0 || (fields[0] != "on" && fields[0] != "off") {
+			continue // "Features for eth0:" header
+		}
+		features[parts[0]] = Feature{
+			Enabled: fields[0] == "on",
+			Fixed:   strings.Contains(parts[1], "[fixed]"),
+		}
+	}
+
+	return features, nil
+}
+
+// execSetFeatures runs `ethtool -K`
+func (e *Ethtool) execSetFeatures(ctx context.Context, name string, features map[string]bool) error {
+	args := append([]string{"-K", name}, onOffArgs(features)...)
+	output, err := e.runner.Run(ctx, "ethtool", args...)
+	if err != nil {
+		return fmt.Errorf("failed to set features: %w, output: %s", err, output)
+	}
+	return nil
+}
+
+// execGetPause parses the output of `ethtool -a`
+func (e *Ethtool) execGetPause(ctx context.Context, name string) (PauseParams, error) {
+	output, err := e.runner.Run(ctx, "ethtool", "-a", name)
+	if err != nil {
+		return PauseParams{}, err
+	}
+
+	var p PauseParams
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "Autonegotiate":
+			p.Autoneg = value
+		case "RX":
+			p.RX = value
+		case "TX":
+			p.TX = value
+		}
+	}
+
+	return p, nil
+}
+
+// execSetPause runs `ethtool -A` with every setting present in p
+func (e *Ethtool) execSetPause(ctx context.Context, name string, p PauseParams) error {
+	args := []string{"-A", name}
+	for _, opt := range []struct{ name, value string }{
+		{"autoneg", p.Autoneg},
+		{"rx", p.RX},
+		{"tx", p.TX},
+	} {
+		if opt.value != "" {
+			args = append(args, opt.name, opt.value)
+		}
+	}
+
+	output, err := e.runner.Run(ctx, "ethtool", args...)
+	if err != nil {
+		return fmt.Errorf("failed to set pause parameters: %w, output: %s", err, output)
+	}
+	return nil
+}
+
+// execGetPrivFlags parses the output of `ethtool --show-priv-flags`
+func (e *Ethtool) execGetPrivFlags(ctx context.Context, name string) (map[string]bool, error) {
+	output, err := e.runner.Run(ctx, "ethtool", "--show-priv-flags", name)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		if value != "on" && value != "off" {
+			continue // "Private flags for eth0:" header
+		}
+		flags[strings.TrimSpace(parts[0])] = value == "on"
+	}
+
+	return flags, nil
+}
+
+// execSetPrivFlags runs `ethtool --set-priv-flags`
+func (e *Ethtool) execSetPrivFlags(ctx context.Context, name string, flags map[string]bool) error {
+	args := append([]string{"--set-priv-flags", name}, onOffArgs(flags)...)
+	output, err := e.runner.Run(ctx, "ethtool", args...)
+	if err != nil {
+		return fmt.Errorf("failed to set private flags: %w, output: %s", err, output)
+	}
+	return nil
+}
+
+// onOffArgs formats name/state pairs sorted by name, so the command line
+// is stable for fixtures
+func onOffArgs(states map[string]bool) []string {
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		state := "off"
+		if states[name] {
+			state = "on"
+		}
+		args = append(args, name, state)
+	}
+	return args
+}
+
+// execGetStats parses the output of `ethtool -S`
+func (e *Ethtool) execGetStats(ctx context.Context, name string) (map[string]uint64, error) {
+	output, err := e.runner.Run(ctx, "ethtool", "-S", name)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make(map[string]uint64)
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			continue // "NIC statistics:" header
+		}
+		counters[strings.TrimSpace(parts[0])] = value
+	}
+
+	return counters, nil
+}
+
+// execGetFEC parses the output of `ethtool --show-fec`
+func (e *Ethtool) execGetFEC(ctx context.Context, name string) (FECParams, error) {
+	output, err := e.runner.Run(ctx, "ethtool", "--show-fec", name)
+	if err != nil {
+		return FECParams{}, err
+	}
+
+	var p FECParams
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		switch {
+		case strings.HasSuffix(key, "Configured FEC encodings"):
+			p.Configured = strings.Fields(parts[1])
+			found = true
+		case key == "Active FEC encoding":
+			p.Active = strings.TrimSpace(parts[1])
+			found = true
+		}
+	}
+
+	if !found {
+		return FECParams{}, fmt.Errorf("FEC parameters not found for %s", name)
+	}
+	return p, nil
+}
+
+// execSetFEC runs `ethtool --set-fec`
+func (e *Ethtool) execSetFEC(ctx context.Context, name string, encoding string) error {
+	args := append([]string{"--set-fec", name, "encoding"}, strings.Fields(encoding)...)
+	output, err := e.runner.Run(ctx, "ethtool", args...)
+	if err != nil {
+		return fmt.Errorf("failed to set FEC: %w, output: %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package system
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// newReplayEthtool returns an exec backend replaying testdata/mlx5.json,
+// recorded on a node with a down 400G Ethernet port and an up IPoIB port
+func newReplayEthtool(t *testing.T) *Ethtool {
+	t.Helper()
+	fixture, err := LoadFixture("testdata/mlx5.json")
+	if err != nil {
+		t.Fatalf("LoadFixture: %v", err)
+	}
+	e, err := NewEthtoolBackend(BackendExec, NewReplayRunner(fixture), 0)
+	if err != nil {
+		t.Fatalf("NewEthtoolBackend: %v", err)
+	}
+	return e
+}
+
+func TestReplayUnrecorded(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	if _, _, err := e.GetRingBufferSettings(ctx, "eth9"); err == nil {
+		t.Error("GetRingBufferSettings(eth9) succeeded without a recording")
+	}
+}
+
+func TestReplayRingBufferSettings(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	cur, max, err := e.GetRingBufferSettings(ctx, "eth0")
+	if err != nil {
+		t.Fatalf("GetRingBufferSettings: %v", err)
+	}
+	if cur != (RingParams{RX: 1024, TX: 1024}) || max != (RingParams{RX: 8192, TX: 8192}) {
+		t.Errorf("GetRingBufferSettings = %+v, %+v", cur, max)
+	}
+}
+
+func TestParseCurrentMax(t *testing.T) {
+	output := "Ring parameters for eth0:\n" +
+		"Pre-set maximums:\n" +
+		"RX:\t\t8192\n" +
+		"RX Mini:\tn/a\n" +
+		"TX:\t\t8192\n" +
+		"Current hardware settings:\n" +
+		"RX:\t\t1024\n" +
+		"TX:\t\t512\n" +
+		"TCP data split:\tauto\n"
+
+	cur, max := parseCurrentMax([]byte(output))
+	if want := map[string]string{"RX": "8192", "TX": "8192"}; !reflect.DeepEqual(max, want) {
+		t.Errorf("max = %v, want %v", max, want)
+	}
+	if want := map[string]string{"RX": "1024", "TX": "512", "TCP data split": "auto"}; !reflect.DeepEqual(cur, want) {
+		t.Errorf("cur = %v, want %v", cur, want)
+	}
+
+	if got := ringParamsFrom(cur); got != (RingParams{RX: 1024, TX: 512, TCPDataSplit: "auto"}) {
+		t.Errorf("ringParamsFrom = %+v", got)
+	}
+}
+
+func TestReplayChannels(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	cur, max, err := e.GetChannels(ctx, "eth0")
+	if err != nil {
+		t.Fatalf("GetChannels: %v", err)
+	}
+	if cur != (Channels{Combined: 8}) || max != (Channels{Combined: 63}) {
+		t.Errorf("GetChannels = %+v, %+v", cur, max)
+	}
+}
+
+func TestReplayCoalesce(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	coalesce, err := e.GetCoalesce(ctx, "eth0")
+	if err != nil {
+		t.Fatalf("GetCoalesce: %v", err)
+	}
+	want := Coalesce{
+		AdaptiveRX: "on",
+		AdaptiveTX: "on",
+		Params:     map[string]int{"rx-usecs": 8, "rx-frames": 128, "tx-usecs": 8, "tx-frames": 128},
+	}
+	if !reflect.DeepEqual(coalesce, want) {
+		t.Errorf("GetCoalesce = %+v, want %+v", coalesce, want)
+	}
+}
+
+func TestReplayFeatures(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	features, err := e.GetFeatures(ctx, "eth0")
+	if err != nil {
+		t.Fatalf("GetFeatures: %v", err)
+	}
+	for name, want := range map[string]Feature{
+		"generic-receive-offload": {Enabled: false},
+		"large-receive-offload":   {Enabled: true},
+		"tx-checksum-ipv4":        {Enabled: false, Fixed: true},
+		"hw-tc-offload":           {Enabled: true, Fixed: true},
+		"rx-gro-hw":               {Enabled: false}, // off [requested on]
+	} {
+		if got, ok := features[name]; !ok || got != want {
+			t.Errorf("GetFeatures()[%s] = %+v, %v, want %+v", name, got, ok, want)
+		}
+	}
+
+	// Only tx-checksumming lists one of its features
+	for name, want := range map[string]bool{
+		"tx-checksumming":         true,
+		"generic-receive-offload": false,
+		"hw-tc-offload":           false,
+		"tx-checksum-ipv4":        false,
+	} {
+		if got := FeatureGroupExpanded(features, name); got != want {
+			t.Errorf("FeatureGroupExpanded(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestReplayPause(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	pause, err := e.GetPause(ctx, "eth0")
+	if err != nil || pause != (PauseParams{Autoneg: "on", RX: "off", TX: "on"}) {
+		t.Errorf("GetPause = %+v, %v", pause, err)
+	}
+}
+
+func TestReplayPrivFlags(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	flags, err := e.GetPrivFlags(ctx, "eth0")
+	if err != nil {
+		t.Fatalf("GetPrivFlags: %v", err)
+	}
+	if len(flags) != 6 || !flags["rx_striding_rq"] || flags["rx_cqe_compress"] {
+		t.Errorf("GetPrivFlags = %v", flags)
+	}
+}
+
+func TestReplayStats(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	counters, err := e.GetStats(ctx, "eth0")
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	want := map[string]uint64{"rx_packets": 1000, "rx_out_of_buffer": 100, "rx_discards_phy": 0, "tx_timeout": 0}
+	if !reflect.DeepEqual(counters, want) {
+		t.Errorf("GetStats = %v, want %v", counters, want)
+	}
+}
+
+func TestReplayFEC(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	fec, err := e.GetFEC(ctx, "eth0")
+	if err != nil {
+		t.Fatalf("GetFEC: %v", err)
+	}
+	if !reflect.DeepEqual(fec, FECParams{Configured: []string{"Auto", "RS"}, Active: "BaseR"}) {
+		t.Errorf("GetFEC = %+v", fec)
+	}
+
+	// Encodings set as --set-fec takes them read back as --show-fec prints them
+	if got := FECEncodings("auto rs"); !reflect.DeepEqual(got, fec.Configured) {
+		t.Errorf("FECEncodings(auto rs) = %v, want %v", got, fec.Configured)
+	}
+	if got := FECEncodings("baser llrs off foo"); !reflect.DeepEqual(got, []string{"BaseR", "LLRS", "Off", "foo"}) {
+		t.Errorf("FECEncodings(baser llrs off foo) = %v", got)
+	}
+}
+
+func TestReplayLinkSpeed(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name string
+		want LinkSpeed
+	}{
+		{"eth0", LinkSpeed{Current: 0, Supported: 400000}}, // link down
+		{"ib0", LinkSpeed{Current: 400000, Supported: 0}},
+	} {
+		speed, err := e.GetLinkSpeed(ctx, tt.name)
+		if err != nil || speed != tt.want {
+			t.Errorf("GetLinkSpeed(%s) = %+v, %v, want %+v", tt.name, speed, err, tt.want)
+		}
+	}
+}
+
+func TestParseLinkSpeed(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   LinkSpeed
+	}{
+		{
+			name:   "up link",
+			output: "Settings for eth0:\n\tSpeed: 200000Mb/s\n\tDuplex: Full\n",
+			want:   LinkSpeed{Current: 200000},
+		},
+		{
+			name: "down link with supported modes on continuation lines",
+			output: "Settings for eth0:\n" +
+				"\tSupported link modes:   100000baseKR4/Full\n" +
+				"\t                        400000baseCR4/Full\n" +
+				"\tSupported pause frame use: Symmetric\n" +
+				"\tAdvertised link modes:  800000baseCR8/Full\n" +
+				"\tSpeed: Unknown!\n",
+			want: LinkSpeed{Supported: 400000},
+		},
+		{
+			name:   "nothing known",
+			output: "Settings for lo:\n\tLink detected: yes\n",
+			want:   LinkSpeed{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseLinkSpeed([]byte(tt.output)); got != tt.want {
+				t.Errorf("parseLinkSpeed = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkModeSpeed(t *testing.T) {
+	for mode, want := range map[string]int{
+		"400000baseCR4/Full": 400000,
+		"100baseT/Half":      100,
+		"Autoneg":            0,
+		"baseT":              0,
+	} {
+		if got := linkModeSpeed(mode); got != want {
+			t.Errorf("linkModeSpeed(%q) = %d, want %d", mode, got, want)
+		}
+	}
+}
+
+func TestReplayDriverInfo(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	info, err := e.GetDriverInfo(ctx, "eth0")
+	if err != nil {
+		t.Fatalf("GetDriverInfo: %v", err)
+	}
+	want := DriverInfo{
+		Driver:          "mlx5_core",
+		Version:         "24.10-1.1.4",
+		FirmwareVersion: "28.39.1002 (MT_0000000838)",
+		BusInfo:         "0000:3b:00.0",
+	}
+	if info != want {
+		t.Errorf("GetDriverInfo = %+v, want %+v", info, want)
+	}
+}
+
+func TestReplaySetRingParams(t *testing.T) {
+	e := newReplayEthtool(t)
+	ctx := context.Background()
+
+	if err := e.SetRingParams(ctx, "eth0", RingParams{RX: 8192, TX: 8192}); err != nil {
+		t.Errorf("SetRingParams(eth0) = %v", err)
+	}
+
+	// ib0 is recorded busy once, then succeeding
+	err := e.SetRingParams(ctx, "ib0", RingParams{RX: 8192, TX: 8192})
+	if !IsBusy(err) {
+		t.Errorf("first SetRingParams(ib0) = %v, want a busy error", err)
+	}
+	if err := e.SetRingParams(ctx, "ib0", RingParams{RX: 8192, TX: 8192}); err != nil {
+		t.Errorf("second SetRingParams(ib0) = %v", err)
+	}
+}
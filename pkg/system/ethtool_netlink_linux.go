@@ -0,0 +1,1049 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Generic netlink controller
+const (
+	genlIDCtrl         = 0x10
+	genlHdrLen         = 4
+	ctrlCmdGetFamily   = 3
+	ctrlAttrFamilyID   = 1
+	ctrlAttrFamilyName = 2
+	nlaFNested         = 0x8000
+	nlaTypeMask        = 0x3fff
+)
+
+// Ethtool generic netlink family (include/uapi/linux/ethtool_netlink.h)
+const (
+	ethtoolGenlName    = "ethtool"
+	ethtoolGenlVersion = 1
+
+	ethtoolMsgStrsetGet    = 1
+	ethtoolMsgLinkmodesGet = 4
+	ethtoolMsgFeaturesGet  = 11
+	ethtoolMsgFeaturesSet  = 12
+	ethtoolMsgPrivflagsGet = 13
+	ethtoolMsgPrivflagsSet = 14
+	ethtoolMsgRingsGet     = 15
+	ethtoolMsgRingsSet     = 16
+	ethtoolMsgChannelsGet  = 17
+	ethtoolMsgChannelsSet  = 18
+	ethtoolMsgCoalesceGet  = 19
+	ethtoolMsgCoalesceSet  = 20
+	ethtoolMsgPauseGet     = 21
+	ethtoolMsgPauseSet     = 22
+	ethtoolMsgFECGet       = 29
+	ethtoolMsgFECSet       = 30
+
+	ethtoolAHeaderDevName = 2
+	ethtoolAHeaderFlags   = 3
+
+	ethtoolAStrsetHeader     = 1
+	ethtoolAStrsetStringsets = 2
+	ethtoolAStringsetsSet    = 1
+	ethtoolAStringsetID      = 1
+	ethtoolAStringsetStrings = 3
+	ethtoolAStringsString    = 1
+	ethtoolAStringIndex      = 1
+	ethtoolAStringValue      = 2
+	ethtoolStringsetFeatures = 4 // ETH_SS_FEATURES
+
+	ethtoolALinkmodesHeader = 1
+	ethtoolALinkmodesOurs   = 3
+	ethtoolALinkmodesSpeed  = 5
+
+	ethtoolABitsetNomask   = 1
+	ethtoolABitsetBits     = 3
+	ethtoolABitsetBitsBit  = 1
+	ethtoolABitsetBitName  = 2
+	ethtoolABitsetBitValue = 3
+
+	ethtoolAFeaturesHeader   = 1
+	ethtoolAFeaturesHW       = 2
+	ethtoolAFeaturesWanted   = 3
+	ethtoolAFeaturesActive   = 4
+	ethtoolAFeaturesNochange = 5
+
+	ethtoolAPrivflagsHeader = 1
+	ethtoolAPrivflagsFlags  = 2
+
+	ethtoolAFECHeader = 1
+	ethtoolAFECModes  = 2
+	ethtoolAFECAuto   = 3
+	ethtoolAFECActive = 4
+
+	ethtoolARingsHeader       = 1
+	ethtoolARingsRXMax        = 2
+	ethtoolARingsRXMiniMax    = 3
+	ethtoolARingsRXJumboMax   = 4
+	ethtoolARingsTXMax        = 5
+	ethtoolARingsRX           = 6
+	ethtoolARingsRXMini       = 7
+	ethtoolARingsRXJumbo      = 8
+	ethtoolARingsTX           = 9
+	ethtoolARingsRXBufLen     = 10
+	ethtoolARingsTCPDataSplit = 11
+	ethtoolARingsCQESize      = 12
+	ethtoolARingsTXPush       = 13
+	ethtoolARingsRXPush       = 14
+
+	ethtoolAChannelsHeader        = 1
+	ethtoolAChannelsRXMax         = 2
+	ethtoolAChannelsTXMax         = 3
+	ethtoolAChannelsOtherMax      = 4
+	ethtoolAChannelsCombinedMax   = 5
+	ethtoolAChannelsRXCount       = 6
+	ethtoolAChannelsTXCount       = 7
+	ethtoolAChannelsOtherCount    = 8
+	ethtoolAChannelsCombinedCount = 9
+
+	ethtoolACoalesceHeader        = 1
+	ethtoolACoalesceUseAdaptiveRX = 11
+	ethtoolACoalesceUseAdaptiveTX = 12
+
+	ethtoolAPauseHeader  = 1
+	ethtoolAPauseAutoneg = 2
+	ethtoolAPauseRX      = 3
+	ethtoolAPauseTX      = 4
+
+	ethtoolTCPDataSplitUnknown  = 0
+	ethtoolTCPDataSplitDisabled = 1
+	ethtoolTCPDataSplitEnabled  = 2
+
+	speedUnknown = 0xffffffff
+)
+
+// fecModes maps the FEC link modes of the kernel to the encodings printed by
+// ethtool --show-fec and accepted by ethtool --set-fec
+var fecModes = []struct {
+	kernel  string // link mode name
+	bit     uint32 // ETHTOOL_LINK_MODE_FEC_*_BIT
+	ethtool string
+}{
+	{"None", 49, "Off"},
+	{"RS", 50, "RS"},
+	{"BASER", 51, "BaseR"},
+	{"LLRS", 74, "LLRS"},
+}
+
+// SIOCETHTOOL ioctl, used for driver info which has no netlink message
+const (
+	siocEthtool     = 0x8946
+	ethtoolGDrvInfo = 0x3
+)
+
+// coalesceAttrs maps ETHTOOL_A_COALESCE_* u32 attributes to ethtool -C names
+var coalesceAttrs = map[uint16]string{
+	2:  "rx-usecs",
+	3:  "rx-frames",
+	4:  "rx-usecs-irq",
+	5:  "rx-frames-irq",
+	6:  "tx-usecs",
+	7:  "tx-frames",
+	8:  "tx-usecs-irq",
+	9:  "tx-frames-irq",
+	10: "stats-block-usecs",
+	13: "pkt-rate-low",
+	14: "rx-usecs-low",
+	15: "rx-frames-low",
+	16: "tx-usecs-low",
+	17: "tx-frames-low",
+	18: "pkt-rate-high",
+	19: "rx-usecs-high",
+	20: "rx-frames-high",
+	21: "tx-usecs-high",
+	22: "tx-frames-high",
+	23: "sample-interval",
+}
+
+// netlinkClient talks to the ethtool generic netlink family
+type netlinkClient struct {
+	family  uint16
+	timeout time.Duration // bounds every request, 0 = no timeout
+
+	// exchange sends a message and returns the replies to it; tests
+	// replace it to stand in for the kernel
+	exchange func(msg []byte) ([][]byte, error)
+}
+
+// newNetlinkClient resolves the ethtool generic netlink family
+func newNetlinkClient(timeout time.Duration) (*netlinkClient, error) {
+	c := &netlinkClient{timeout: timeout, exchange: exchange}
+	family, err := c.resolveFamily(context.Background(), ethtoolGenlName)
+	if err != nil {
+		return nil, err
+	}
+	c.family = family
+
+	return c, nil
+}
+
+// resolveFamily looks up the id of a generic netlink family by name
+func (c *netlinkClient) resolveFamily(ctx context.Context, name string) (uint16, error) {
+	var req bytes.Buffer
+	putAttr(&req, ctrlAttrFamilyName, append([]byte(name), 0))
+
+	replies, err := c.request(ctx, genlIDCtrl, ctrlCmdGetFamily, 1, req.Bytes())
+	if err != nil {
+		return 0, fmt.Errorf("resolving genetlink family %s: %v", name, err)
+	}
+	for _, reply := range replies {
+		if id, ok := parseAttrs(reply)[ctrlAttrFamilyID]; ok && len(id) >= 2 {
+			return binary.NativeEndian.Uint16(id), nil
+		}
+	}
+
+	return 0, fmt.Errorf("genetlink family %s not found", name)
+}
+
+// request sends a generic netlink message and returns the attribute
+// payloads of every reply received before the kernel acknowledges it. The
+// kernel handles the message inside sendmsg, so a driver stuck in its
+// ethtool op blocks the send itself. Each request therefore runs on a
+// socket of its own and is abandoned once ctx is done or the request
+// timeout expires; a request stuck in the driver is left to finish on its
+// own and its replies are dropped.
+func (c *netlinkClient) request(ctx context.Context, family uint16, cmd, version uint8, attrs []byte) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	msg := make([]byte, syscall.NLMSG_HDRLEN+genlHdrLen+len(attrs))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	binary.NativeEndian.PutUint16(msg[4:6], family)
+	binary.NativeEndian.PutUint16(msg[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:12], netlinkSeq)
+	msg[syscall.NLMSG_HDRLEN] = cmd
+	msg[syscall.NLMSG_HDRLEN+1] = version
+	copy(msg[syscall.NLMSG_HDRLEN+genlHdrLen:], attrs)
+
+	type result struct {
+		replies [][]byte
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		replies, err := c.exchange(msg)
+		done <- result{replies, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.replies, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// netlinkSeq is the sequence number of every request; each request has a
+// socket of its own
+const netlinkSeq = 1
+
+// exchange sends a generic netlink message on a new socket and returns the
+// attribute payloads of every reply received before the kernel
+// acknowledges it
+func exchange(msg []byte) ([][]byte, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_GENERIC)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var replies [][]byte
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != netlinkSeq {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, fmt.Errorf("truncated netlink error message")
+				}
+				if code := int32(binary.NativeEndian.Uint32(m.Data[0:4])); code != 0 {
+					return nil, syscall.Errno(-code)
+				}
+				return replies, nil
+			case syscall.NLMSG_DONE:
+				return replies, nil
+			default:
+				if len(m.Data) >= genlHdrLen {
+					// buf is reused by the next Recvfrom, keep a copy
+					replies = append(replies, append([]byte(nil), m.Data[genlHdrLen:]...))
+				}
+			}
+		}
+	}
+}
+
+// ethtoolRequest sends an ethtool message for a device and returns the
+// attributes of the first reply, bounded like request
+func (c *netlinkClient) ethtoolRequest(ctx context.Context, cmd uint8, name string, headerAttr uint16, flags uint32, extra []byte) (map[uint16][]byte, error) {
+	var header bytes.Buffer
+	putAttr(&header, ethtoolAHeaderDevName, append([]byte(name), 0))
+	if flags != 0 {
+		putUint32Attr(&header, ethtoolAHeaderFlags, flags)
+	}
+
+	var req bytes.Buffer
+	putAttr(&req, headerAttr|nlaFNested, header.Bytes())
+	req.Write(extra)
+
+	replies, err := c.request(ctx, c.family, cmd, ethtoolGenlVersion, req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(replies) == 0 {
+		return map[uint16][]byte{}, nil
+	}
+
+	return parseAttrs(replies[0]), nil
+}
+
+// getLinkSpeed returns the link speed and the highest speed among the
+// supported link modes from one ETHTOOL_MSG_LINKMODES_GET
+func (c *netlinkClient) getLinkSpeed(ctx context.Context, name string) (LinkSpeed, error) {
+	// Without the compact flag the bitset lists every supported mode by name
+	attrs, err := c.ethtoolRequest(ctx, ethtoolMsgLinkmodesGet, name, ethtoolALinkmodesHeader, 0, nil)
+	if err != nil {
+		return LinkSpeed{}, err
+	}
+
+	var speed LinkSpeed
+	if cur, ok := attrUint32(attrs, ethtoolALinkmodesSpeed); ok && cur != speedUnknown {
+		speed.Current = int(cur)
+	}
+
+	bitset := parseAttrs(attrs[ethtoolALinkmodesOurs])
+	walkAttrs(bitset[ethtoolABitsetBits], func(_ uint16, bit []byte) {
+		if mode, ok := parseAttrs(bit)[ethtoolABitsetBitName]; ok {
+			if s := linkModeSpeed(cString(mode)); s > speed.Supported {
+				speed.Supported = s
+			}
+		}
+	})
+
+	if speed == (LinkSpeed{}) {
+		return LinkSpeed{}, fmt.Errorf("speed not found for %s", name)
+	}
+	return speed, nil
+}
+
+// getRings returns the ring parameters from ETHTOOL_MSG_RINGS_GET
+func (c *netlinkClient) getRings(ctx context.Context, name string) (cur, max RingParams, err error) {
+	attrs, err := c.ethtoolRequest(ctx, ethtoolMsgRingsGet, name, ethtoolARingsHeader, 0, nil)
+	if err != nil {
+		return RingParams{}, RingParams{}, err
+	}
+
+	for attr, field := range map[uint16]*int{
+		ethtoolARingsRXMax:      &max.RX,
+		ethtoolARingsRXMiniMax:  &max.RXMini,
+		ethtoolARingsRXJumboMax: &max.RXJumbo,
+		ethtoolARingsTXMax:      &max.TX,
+		ethtoolARingsRX:         &cur.RX,
+		ethtoolARingsRXMini:     &cur.RXMini,
+		ethtoolARingsRXJumbo:    &cur.RXJumbo,
+		ethtoolARingsTX:         &cur.TX,
+		ethtoolARingsRXBufLen:   &cur.RXBufLen,
+		ethtoolARingsCQESize:    &cur.CQESize,
+	} {
+		if v, ok := attrUint32(attrs, attr); ok {
+			*field = int(v)
+		}
+	}
+	if v, ok := attrUint8(attrs, ethtoolARingsTXPush); ok {
+		cur.TXPush = onOff(v != 0)
+	}
+	if v, ok := attrUint8(attrs, ethtoolARingsRXPush); ok {
+		cur.RXPush = onOff(v != 0)
+	}
+	if v, ok := attrUint8(attrs, ethtoolARingsTCPDataSplit); ok {
+		switch v {
+		case ethtoolTCPDataSplitDisabled:
+			cur.TCPDataSplit = "off"
+		case ethtoolTCPDataSplitEnabled:
+			cur.TCPDataSplit = "on"
+		}
+	}
+
+	return cur, max, nil
+}
+
+// setRings changes the ring parameters with ETHTOOL_MSG_RINGS_SET
+func (c *netlinkClient) setRings(ctx context.Context, name string, p RingParams) error {
+	var extra bytes.Buffer
+	for _, field := range []struct {
+		attr  uint16
+		value int
+	}{
+		{ethtoolARingsRX, p.RX},
+		{ethtoolARingsRXMini, p.RXMini},
+		{ethtoolARingsRXJumbo, p.RXJumbo},
+		{ethtoolARingsTX, p.TX},
+		{ethtoolARingsRXBufLen, p.RXBufLen},
+		{ethtoolARingsCQESize, p.CQESize},
+	} {
+		if field.value > 0 {
+			putUint32Attr(&extra, field.attr, uint32(field.value))
+		}
+	}
+	if p.TXPush != "" {
+		putUint8Attr(&extra, ethtoolARingsTXPush, boolUint8(p.TXPush == "on"))
+	}
+	if p.RXPush != "" {
+		putUint8Attr(&extra, ethtoolARingsRXPush, boolUint8(p.RXPush == "on"))
+	}
+	switch p.TCPDataSplit {
+	case "on":
+		putUint8Attr(&extra, ethtoolARingsTCPDataSplit, ethtoolTCPDataSplitEnabled)
+	case "off":
+		putUint8Attr(&extra, ethtoolARingsTCPDataSplit, ethtoolTCPDataSplitDisabled)
+	case "auto":
+		putUint8Attr(&extra, ethtoolARingsTCPDataSplit, ethtoolTCPDataSplitUnknown)
+	}
+
+	_, err := c.ethtoolRequest(ctx, ethtoolMsgRingsSet, name, ethtoolARingsHeader, 0, extra.Bytes())
+	return err
+}
+
+// getChannels returns the channel counts from ETHTOOL_MSG_CHANNELS_GET
+func (c *netlinkClient) getChannels(ctx context.Context, name string) (cur, max Channels, err error) {
+	attrs, err := c.ethtoolRequest(ctx, ethtoolMsgChannelsGet, name, ethtoolAChannelsHeader, 0, nil)
+	if err != nil {
+		return Channels{}, Channels{}, err
+	}
+
+	for attr, field := range map[uint16]*int{
+		ethtoolAChannelsRXMax:         &max.RX,
+		ethtoolAChannelsTXMax:         &max.TX,
+		ethtoolAChannelsOtherMax:      &max.Other,
+		ethtoolAChannelsCombinedMax:   &max.Combined,
+		ethtoolAChannelsRXCount:       &cur.RX,
+		ethtoolAChannelsTXCount:       &cur.TX,
+		ethtoolAChannelsOtherCount:    &cur.Other,
+		ethtoolAChannelsCombinedCount: &cur.Combined,
+	} {
+		if v, ok := attrUint32(attrs, attr); ok {
+			*field = int(v)
+		}
+	}
+
+	return cur, max, nil
+}
+
+// setChannels changes the channel counts with ETHTOOL_MSG_CHANNELS_SET
+func (c *netlinkClient) setChannels(ctx context.Context, name string, ch Channels) error {
+	var extra bytes.Buffer
+	for _, field := range []struct {
+		attr  uint16
+		value int
+	}{
+		{ethtoolAChannelsRXCount, ch.RX},
+		{ethtoolAChannelsTXCount, ch.TX},
+		{ethtoolAChannelsOtherCount, ch.Other},
+		{ethtoolAChannelsCombinedCount, ch.Combined},
+	} {
+		if field.value > 0 {
+			putUint32Attr(&extra, field.attr, uint32(field.value))
+		}
+	}
+
+	_, err := c.ethtoolRequest(ctx, ethtoolMsgChannelsSet, name, ethtoolAChannelsHeader, 0, extra.Bytes())
+	return err
+}
+
+// getCoalesce returns the coalescing settings from ETHTOOL_MSG_COALESCE_GET.
+// The kernel only includes parameters the driver supports.
+func (c *netlinkClient) getCoalesce(ctx context.Context, name string) (Coalesce, error) {
+	attrs, err := c.ethtoolRequest(ctx, ethtoolMsgCoalesceGet, name, ethtoolACoalesceHeader, 0, nil)
+	if err != nil {
+		return Coalesce{}, err
+	}
+
+	co := Coalesce{Params: make(map[string]int)}
+	for attr, param := range coalesceAttrs {
+		if v, ok := attrUint32(attrs, attr); ok {
+			co.Params[param] = int(v)
+		}
+	}
+	if v, ok := attrUint8(attrs, ethtoolACoalesceUseAdaptiveRX); ok {
+		co.AdaptiveRX = onOff(v != 0)
+	}
+	if v, ok := attrUint8(attrs, ethtoolACoalesceUseAdaptiveTX); ok {
+		co.AdaptiveTX = onOff(v != 0)
+	}
+
+	return co, nil
+}
+
+// setCoalesce changes coalescing settings with ETHTOOL_MSG_COALESCE_SET
+func (c *netlinkClient) setCoalesce(ctx context.Context, name string, co Coalesce) error {
+	for param := range co.Params {
+		if !containsValue(coalesceAttrs, param) {
+			return fmt.Errorf("unknown coalesce parameter %s", param)
+		}
+	}
+
+	var extra bytes.Buffer
+	for attr, param := range coalesceAttrs {
+		if v, ok := co.Params[param]; ok {
+			putUint32Attr(&extra, attr, uint32(v))
+		}
+	}
+	if co.AdaptiveRX != "" {
+		putUint8Attr(&extra, ethtoolACoalesceUseAdaptiveRX, boolUint8(co.AdaptiveRX == "on"))
+	}
+	if co.AdaptiveTX != "" {
+		putUint8Attr(&extra, ethtoolACoalesceUseAdaptiveTX, boolUint8(co.AdaptiveTX == "on"))
+	}
+
+	_, err := c.ethtoolRequest(ctx, ethtoolMsgCoalesceSet, name, ethtoolACoalesceHeader, 0, extra.Bytes())
+	return err
+}
+
+// getPause returns the pause frame settings from ETHTOOL_MSG_PAUSE_GET
+func (c *netlinkClient) getPause(ctx context.Context, name string) (PauseParams, error) {
+	attrs, err := c.ethtoolRequest(ctx, ethtoolMsgPauseGet, name, ethtoolAPauseHeader, 0, nil)
+	if err != nil {
+		return PauseParams{}, err
+	}
+
+	var p PauseParams
+	for attr, field := range map[uint16]*string{
+		ethtoolAPauseAutoneg: &p.Autoneg,
+		ethtoolAPauseRX:      &p.RX,
+		ethtoolAPauseTX:      &p.TX,
+	} {
+		if v, ok := attrUint8(attrs, attr); ok {
+			*field = onOff(v != 0)
+		}
+	}
+
+	return p, nil
+}
+
+// setPause changes the pause frame settings with ETHTOOL_MSG_PAUSE_SET
+func (c *netlinkClient) setPause(ctx context.Context, name string, p PauseParams) error {
+	var extra bytes.Buffer
+	for _, field := range []struct {
+		attr  uint16
+		value string
+	}{
+		{ethtoolAPauseAutoneg, p.Autoneg},
+		{ethtoolAPauseRX, p.RX},
+		{ethtoolAPauseTX, p.TX},
+	} {
+		if field.value != "" {
+			putUint8Attr(&extra, field.attr, boolUint8(field.value == "on"))
+		}
+	}
+
+	_, err := c.ethtoolRequest(ctx, ethtoolMsgPauseSet, name, ethtoolAPauseHeader, 0, extra.Bytes())
+	return err
+}
+
+// getFeatures returns the offload features from ETHTOOL_MSG_FEATURES_GET,
+// keyed by their kernel names plus the names ethtool -k prints for groups
+// of them (see legacyFeatures)
+func (c *netlinkClient) getFeatures(ctx context.Context, name string) (map[string]Feature, error) {
+	features, err := c.kernelFeatures(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	addLegacyFeatures(features)
+	return features, nil
+}
+
+// kernelFeatures returns the offload features keyed by their kernel names.
+// The feature bitsets only list the bits that are set, so the names come
+// from the ETH_SS_FEATURES string set; a feature that is off and cannot be
+// changed appears in none of them.
+func (c *netlinkClient) kernelFeatures(ctx context.Context, name string) (map[string]Feature, error) {
+	names, err := c.stringSet(ctx, name, ethtoolStringsetFeatures)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := c.ethtoolRequest(ctx, ethtoolMsgFeaturesGet, name, ethtoolAFeaturesHeader, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	features := featureStates(names,
+		bitsetNames(attrs[ethtoolAFeaturesHW]),
+		bitsetNames(attrs[ethtoolAFeaturesActive]),
+		bitsetNames(attrs[ethtoolAFeaturesNochange]))
+	if len(features) == 0 {
+		return nil, fmt.Errorf("features not found for %s", name)
+	}
+	return features, nil
+}
+
+// featureStates returns the state of every named feature, and of any
+// feature listed in the bitsets but missing from names. A feature is fixed
+// when the hardware cannot change it or the kernel never lets it change,
+// as ethtool -k prints [fixed].
+func featureStates(names []string, hw, active, nochange map[string]bool) map[string]Feature {
+	features := make(map[string]Feature)
+	add := func(feature string) {
+		features[feature] = Feature{Enabled: active[feature], Fixed: !hw[feature] || nochange[feature]}
+	}
+	for _, feature := range names {
+		// Unused feature bits have no name
+		if feature != "" {
+			add(feature)
+		}
+	}
+	for _, bits := range []map[string]bool{hw, active, nochange} {
+		for feature := range bits {
+			add(feature)
+		}
+	}
+	return features
+}
+
+// stringSet returns the strings of a string set of a device with
+// ETHTOOL_MSG_STRSET_GET, indexed by bit
+func (c *netlinkClient) stringSet(ctx context.Context, name string, id uint32) ([]string, error) {
+	var set bytes.Buffer
+	putUint32Attr(&set, ethtoolAStringsetID, id)
+	var sets bytes.Buffer
+	putAttr(&sets, ethtoolAStringsetsSet|nlaFNested, set.Bytes())
+	var extra bytes.Buffer
+	putAttr(&extra, ethtoolAStrsetStringsets|nlaFNested, sets.Bytes())
+
+	attrs, err := c.ethtoolRequest(ctx, ethtoolMsgStrsetGet, name, ethtoolAStrsetHeader, 0, extra.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return parseStringSet(attrs[ethtoolAStrsetStringsets], id), nil
+}
+
+// parseStringSet returns the strings of string set id from an
+// ETHTOOL_A_STRSET_STRINGSETS attribute, indexed by bit
+func parseStringSet(b []byte, id uint32) []string {
+	var values []string
+	walkAttrs(b, func(_ uint16, set []byte) {
+		attrs := parseAttrs(set)
+		if setID, ok := attrUint32(attrs, ethtoolAStringsetID); !ok || setID != id {
+			return
+		}
+		walkAttrs(attrs[ethtoolAStringsetStrings], func(_ uint16, str []byte) {
+			attrs := parseAttrs(str)
+			index, ok := attrUint32(attrs, ethtoolAStringIndex)
+			if !ok || index >= 1<<16 {
+				return
+			}
+			for int(index) >= len(values) {
+				values = append(values, "")
+			}
+			values[index] = cString(attrs[ethtoolAStringValue])
+		})
+	})
+	return values
+}
+
+// addLegacyFeatures adds the ethtool -k name of every group of kernel
+// features present. A group is on when any of its features is, and fixed
+// when none of them can be changed.
+func addLegacyFeatures(features map[string]Feature) {
+	for _, legacy := range legacyFeatures {
+		group := Feature{Fixed: true}
+		found := false
+		for feature, state := range features {
+			if ok, _ := path.Match(legacy.pattern, feature); ok {
+				found = true
+				group.Enabled = group.Enabled || state.Enabled
+				group.Fixed = group.Fixed && state.Fixed
+			}
+		}
+		if found {
+			features[legacy.name] = group
+		}
+	}
+}
+
+// setFeatures changes offload features with ETHTOOL_MSG_FEATURES_SET. A
+// group name such as generic-receive-offload changes every feature of the
+// group that can be changed, like ethtool -K.
+func (c *netlinkClient) setFeatures(ctx context.Context, name string, features map[string]bool) error {
+	current, err := c.kernelFeatures(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool)
+	for feature, enabled := range features {
+		pattern := FeatureName(feature)
+		for _, legacy := range legacyFeatures {
+			if legacy.name == pattern {
+				pattern = legacy.pattern
+			}
+		}
+		found := false
+		for kernel, state := range current {
+			if ok, _ := path.Match(pattern, kernel); ok && !state.Fixed {
+				wanted[kernel] = enabled
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("feature %s cannot be changed on %s", feature, name)
+		}
+	}
+
+	var extra bytes.Buffer
+	putBitset(&extra, ethtoolAFeaturesWanted, wanted, false)
+	_, err = c.ethtoolRequest(ctx, ethtoolMsgFeaturesSet, name, ethtoolAFeaturesHeader, 0, extra.Bytes())
+	return err
+}
+
+// getPrivFlags returns the driver private flags from
+// ETHTOOL_MSG_PRIVFLAGS_GET
+func (c *netlinkClient) getPrivFlags(ctx context.Context, name string) (map[string]bool, error) {
+	attrs, err := c.ethtoolRequest(ctx, ethtoolMsgPrivflagsGet, name, ethtoolAPrivflagsHeader, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bitsetNames(attrs[ethtoolAPrivflagsFlags]), nil
+}
+
+// setPrivFlags changes driver private flags with ETHTOOL_MSG_PRIVFLAGS_SET
+func (c *netlinkClient) setPrivFlags(ctx context.Context, name string, flags map[string]bool) error {
+	var extra bytes.Buffer
+	putBitset(&extra, ethtoolAPrivflagsFlags, flags, false)
+	_, err := c.ethtoolRequest(ctx, ethtoolMsgPrivflagsSet, name, ethtoolAPrivflagsHeader, 0, extra.Bytes())
+	return err
+}
+
+// getFEC returns the FEC settings from ETHTOOL_MSG_FEC_GET, named as
+// ethtool --show-fec prints them
+func (c *netlinkClient) getFEC(ctx context.Context, name string) (FECParams, error) {
+	attrs, err := c.ethtoolRequest(ctx, ethtoolMsgFECGet, name, ethtoolAFECHeader, 0, nil)
+	if err != nil {
+		return FECParams{}, err
+	}
+
+	var p FECParams
+	if auto, ok := attrUint8(attrs, ethtoolAFECAuto); ok && auto != 0 {
+		p.Configured = append(p.Configured, "Auto")
+	}
+	modes := bitsetNames(attrs[ethtoolAFECModes])
+	for _, mode := range fecModes {
+		if modes[mode.kernel] {
+			p.Configured = append(p.Configured, mode.ethtool)
+		}
+	}
+	if active, ok := attrUint32(attrs, ethtoolAFECActive); ok {
+		for _, mode := range fecModes {
+			if mode.bit == active {
+				p.Active = mode.ethtool
+			}
+		}
+	}
+
+	if len(p.Configured) == 0 && p.Active == "" {
+		return FECParams{}, fmt.Errorf("FEC parameters not found for %s", name)
+	}
+	return p, nil
+}
+
+// setFEC changes the FEC encodings with ETHTOOL_MSG_FEC_SET. The encodings
+// are given as to ethtool --set-fec, e.g. "auto rs", and replace the
+// configured ones.
+func (c *netlinkClient) setFEC(ctx context.Context, name string, encoding string) error {
+	modes := make(map[string]bool)
+	auto := false
+	for _, enc := range strings.Fields(encoding) {
+		if strings.EqualFold(enc, "auto") {
+			auto = true
+			continue
+		}
+		found := false
+		for _, mode := range fecModes {
+			if strings.EqualFold(enc, mode.ethtool) {
+				modes[mode.kernel] = true
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown FEC encoding %s", enc)
+		}
+	}
+
+	var extra bytes.Buffer
+	putBitset(&extra, ethtoolAFECModes, modes, true)
+	putUint8Attr(&extra, ethtoolAFECAuto, boolUint8(auto))
+	_, err := c.ethtoolRequest(ctx, ethtoolMsgFECSet, name, ethtoolAFECHeader, 0, extra.Bytes())
+	return err
+}
+
+// driverInfo returns the driver information with ioctlDriverInfo, bounded
+// by ctx and the request timeout like the netlink requests. An ioctl stuck
+// in the driver cannot be interrupted and is left to finish on its own.
+func (c *netlinkClient) driverInfo(ctx context.Context, name string) (DriverInfo, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		info DriverInfo
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		info, err := ioctlDriverInfo(name)
+		done <- result{info, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.info, r.err
+	case <-ctx.Done():
+		return DriverInfo{}, ctx.Err()
+	}
+}
+
+// containsValue reports whether value is one of the map's values
+func containsValue(m map[uint16]string, value string) bool {
+	for _, v := range m {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// putAttr appends a netlink attribute, padded to 4 bytes
+func putAttr(buf *bytes.Buffer, attrType uint16, data []byte) {
+	var hdr [syscall.SizeofNlAttr]byte
+	binary.NativeEndian.PutUint16(hdr[0:2], uint16(syscall.SizeofNlAttr+len(data)))
+	binary.NativeEndian.PutUint16(hdr[2:4], attrType)
+	buf.Write(hdr[:])
+	buf.Write(data)
+	if pad := nlaAlign(len(data)) - len(data); pad > 0 {
+		buf.Write(make([]byte, pad))
+	}
+}
+
+// putUint32Attr appends a u32 netlink attribute
+func putUint32Attr(buf *bytes.Buffer, attrType uint16, v uint32) {
+	data := make([]byte, 4)
+	binary.NativeEndian.PutUint32(data, v)
+	putAttr(buf, attrType, data)
+}
+
+// putUint8Attr appends a u8 netlink attribute
+func putUint8Attr(buf *bytes.Buffer, attrType uint16, v uint8) {
+	putAttr(buf, attrType, []byte{v})
+}
+
+// putBitset appends a bitset attribute listing bits by name. Without
+// nomask, listed bits are set or cleared and the others left unchanged;
+// with nomask, the listed bits are set and every other bit is cleared.
+func putBitset(buf *bytes.Buffer, attrType uint16, bits map[string]bool, nomask bool) {
+	names := make([]string, 0, len(bits))
+	for name := range bits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list bytes.Buffer
+	for _, name := range names {
+		var bit bytes.Buffer
+		putAttr(&bit, ethtoolABitsetBitName, append([]byte(name), 0))
+		if bits[name] {
+			putAttr(&bit, ethtoolABitsetBitValue, nil)
+		}
+		putAttr(&list, ethtoolABitsetBitsBit|nlaFNested, bit.Bytes())
+	}
+
+	var bitset bytes.Buffer
+	if nomask {
+		putAttr(&bitset, ethtoolABitsetNomask, nil)
+	}
+	putAttr(&bitset, ethtoolABitsetBits|nlaFNested, list.Bytes())
+	putAttr(buf, attrType|nlaFNested, bitset.Bytes())
+}
+
+// bitsetNames returns the bits listed in a bitset attribute by name and
+// whether each is set. A bitset without a mask only lists the set bits.
+func bitsetNames(b []byte) map[string]bool {
+	bitset := parseAttrs(b)
+	_, nomask := bitset[ethtoolABitsetNomask]
+
+	names := make(map[string]bool)
+	walkAttrs(bitset[ethtoolABitsetBits], func(_ uint16, bit []byte) {
+		attrs := parseAttrs(bit)
+		// Unused feature bits have no name
+		if name := cString(attrs[ethtoolABitsetBitName]); name != "" {
+			_, value := attrs[ethtoolABitsetBitValue]
+			names[name] = nomask || value
+		}
+	})
+	return names
+}
+
+// parseAttrs splits a netlink attribute stream into a map keyed by type
+func parseAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	walkAttrs(b, func(attrType uint16, data []byte) {
+		attrs[attrType] = data
+	})
+	return attrs
+}
+
+// walkAttrs calls fn for every attribute in order, including repeated ones
+func walkAttrs(b []byte, fn func(attrType uint16, data []byte)) {
+	for len(b) >= syscall.SizeofNlAttr {
+		length := int(binary.NativeEndian.Uint16(b[0:2]))
+		attrType := binary.NativeEndian.Uint16(b[2:4]) & nlaTypeMask
+		if length < syscall.SizeofNlAttr || length > len(b) {
+			break
+		}
+		fn(attrType, b[syscall.SizeofNlAttr:length])
+		if aligned := nlaAlign(length); aligned < len(b) {
+			b = b[aligned:]
+		} else {
+			break
+		}
+	}
+}
+
+// attrUint32 returns a u32 attribute value
+func attrUint32(attrs map[uint16][]byte, attrType uint16) (uint32, bool) {
+	v, ok := attrs[attrType]
+	if !ok || len(v) < 4 {
+		return 0, false
+	}
+	return binary.NativeEndian.Uint32(v), true
+}
+
+// attrUint8 returns a u8 attribute value
+func attrUint8(attrs map[uint16][]byte, attrType uint16) (uint8, bool) {
+	v, ok := attrs[attrType]
+	if !ok || len(v) < 1 {
+		return 0, false
+	}
+	return v[0], true
+}
+
+func boolUint8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func nlaAlign(n int) int {
+	return (n + syscall.NLA_ALIGNTO - 1) &^ (syscall.NLA_ALIGNTO - 1)
+}
+
+// ethtoolDrvInfo mirrors struct ethtool_drvinfo
+type ethtoolDrvInfo struct {
+	Cmd         uint32
+	Driver      [32]byte
+	Version     [32]byte
+	FWVersion   [32]byte
+	BusInfo     [32]byte
+	EROMVersion [32]byte
+	Reserved2   [12]byte
+	NPrivFlags  uint32
+	NStats      uint32
+	TestInfoLen uint32
+	EEDumpLen   uint32
+	RegDumpLen  uint32
+}
+
+// ifreqData mirrors struct ifreq with the ifr_data member
+type ifreqData struct {
+	Name [syscall.IFNAMSIZ]byte
+	Data unsafe.Pointer
+	_    [16]byte
+}
+
+// ioctlDriverInfo returns the driver information using the
+// ETHTOOL_GDRVINFO ioctl
+func ioctlDriverInfo(name string) (DriverInfo, error) {
+	if len(name) >= syscall.IFNAMSIZ {
+		return DriverInfo{}, fmt.Errorf("interface name too long: %s", name)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return DriverInfo{}, err
+	}
+	defer syscall.Close(fd)
+
+	info := ethtoolDrvInfo{Cmd: ethtoolGDrvInfo}
+	var ifr ifreqData
+	copy(ifr.Name[:], name)
+	ifr.Data = unsafe.Pointer(&info)
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocEthtool, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return DriverInfo{}, errno
+	}
+
+	driver := cString(info.Driver[:])
+	if driver == "" {
+		return DriverInfo{}, fmt.Errorf("driver not found for %s", name)
+	}
+
+	return DriverInfo{
+		Driver:              driver,
+		Version:             cString(info.Version[:]),
+		FirmwareVersion:     cString(info.FWVersion[:]),
+		ExpansionROMVersion: cString(info.EROMVersion[:]),
+		BusInfo:             cString(info.BusInfo[:]),
+	}, nil
+}
+
+// cString converts a NUL-terminated byte array to a string
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,254 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAttrsRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	putAttr(&buf, 1, []byte("eth0\x00")) // padded to 8 bytes
+	putUint32Attr(&buf, 2, 8192)
+	putUint8Attr(&buf, 3, 1)
+	putAttr(&buf, 4, nil)
+	putUint32Attr(&buf, 2, 4096) // repeated
+
+	if buf.Len()%4 != 0 {
+		t.Fatalf("attributes are %d bytes, not 4-byte aligned", buf.Len())
+	}
+
+	type attr struct {
+		attrType uint16
+		data     []byte
+	}
+	var got []attr
+	walkAttrs(buf.Bytes(), func(attrType uint16, data []byte) {
+		got = append(got, attr{attrType, data})
+	})
+	if len(got) != 5 {
+		t.Fatalf("walkAttrs found %d attributes, want 5", len(got))
+	}
+	if got[0].attrType != 1 || cString(got[0].data) != "eth0" {
+		t.Errorf("attribute 0 = %d %q", got[0].attrType, got[0].data)
+	}
+	if got[3].attrType != 4 || len(got[3].data) != 0 {
+		t.Errorf("attribute 3 = %d %q, want an empty flag", got[3].attrType, got[3].data)
+	}
+
+	// parseAttrs keeps the last of repeated attributes
+	attrs := parseAttrs(buf.Bytes())
+	if v, ok := attrUint32(attrs, 2); !ok || v != 4096 {
+		t.Errorf("attrUint32(2) = %d, %v, want 4096", v, ok)
+	}
+	if v, ok := attrUint8(attrs, 3); !ok || v != 1 {
+		t.Errorf("attrUint8(3) = %d, %v, want 1", v, ok)
+	}
+	if _, ok := attrUint32(attrs, 4); ok {
+		t.Error("attrUint32 accepted an empty attribute")
+	}
+	if _, ok := attrUint8(attrs, 5); ok {
+		t.Error("attrUint8 found a missing attribute")
+	}
+}
+
+func TestWalkAttrsTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	putUint32Attr(&buf, 1, 1)
+	putUint32Attr(&buf, 2, 2)
+	b := buf.Bytes()
+
+	// The second attribute claims more bytes than are left
+	var types []uint16
+	walkAttrs(b[:len(b)-2], func(attrType uint16, _ []byte) {
+		types = append(types, attrType)
+	})
+	if !reflect.DeepEqual(types, []uint16{1}) {
+		t.Errorf("walkAttrs found %v, want [1]", types)
+	}
+
+	// The nested flag is not part of the type
+	buf.Reset()
+	putAttr(&buf, 7|nlaFNested, nil)
+	if _, ok := parseAttrs(buf.Bytes())[7]; !ok {
+		t.Error("nested attribute 7 not found")
+	}
+}
+
+func TestBitsetRoundTrip(t *testing.T) {
+	bits := map[string]bool{"rx-gro": true, "rx-lro": false, "tx-tcp-segmentation": true}
+
+	var buf bytes.Buffer
+	putBitset(&buf, 3, bits, false)
+	if got := bitsetNames(parseAttrs(buf.Bytes())[3]); !reflect.DeepEqual(got, bits) {
+		t.Errorf("bitsetNames = %v, want %v", got, bits)
+	}
+
+	// Without a mask every listed bit is set
+	buf.Reset()
+	putBitset(&buf, 2, map[string]bool{"RS": true}, true)
+	if got := bitsetNames(parseAttrs(buf.Bytes())[2]); !reflect.DeepEqual(got, map[string]bool{"RS": true}) {
+		t.Errorf("bitsetNames = %v, want RS set", got)
+	}
+}
+
+func TestAddLegacyFeatures(t *testing.T) {
+	features := map[string]Feature{
+		"rx-gro":                       {Enabled: true},
+		"tx-tcp-segmentation":          {Enabled: false},
+		"tx-tcp6-segmentation":         {Enabled: true},
+		"tx-tcp-mangleid-segmentation": {Enabled: false, Fixed: true},
+		"tx-checksum-ipv4":             {Enabled: false, Fixed: true},
+		"tx-checksum-ip-generic":       {Enabled: true, Fixed: true},
+	}
+	addLegacyFeatures(features)
+
+	for name, want := range map[string]Feature{
+		"generic-receive-offload":  {Enabled: true},
+		"tcp-segmentation-offload": {Enabled: true},
+		"tx-checksumming":          {Enabled: true, Fixed: true},
+	} {
+		if got, ok := features[name]; !ok || got != want {
+			t.Errorf("%s = %+v, %v, want %+v", name, got, ok, want)
+		}
+	}
+	if _, ok := features["large-receive-offload"]; ok {
+		t.Error("large-receive-offload added without rx-lro")
+	}
+}
+
+func TestUseExec(t *testing.T) {
+	auto := &Ethtool{nl: &netlinkClient{}, fallback: true}
+	netlink := &Ethtool{nl: &netlinkClient{}}
+
+	tests := []struct {
+		name string
+		e    *Ethtool
+		err  error
+		want bool
+	}{
+		{"exec backend", &Ethtool{}, nil, true},
+		{"auto unsupported", auto, syscall.EOPNOTSUPP, true},
+		{"auto unsupported wrapped", auto, fmt.Errorf("rings: %w", syscall.EOPNOTSUPP), true},
+		{"auto rejected", auto, syscall.EINVAL, false},
+		{"auto out of range", auto, syscall.ERANGE, false},
+		{"auto busy", auto, syscall.EBUSY, false},
+		{"auto timed out", auto, context.DeadlineExceeded, false},
+		{"auto parse error", auto, errors.New("features not found for eth0"), false},
+		{"netlink unsupported", netlink, syscall.EOPNOTSUPP, false},
+	}
+	for _, tt := range tests {
+		if got := tt.e.useExec(tt.err); got != tt.want {
+			t.Errorf("%s: useExec(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestParseStringSet(t *testing.T) {
+	stringSet := func(id uint32, values map[uint32]string) []byte {
+		var strs bytes.Buffer
+		for index, value := range values {
+			var str bytes.Buffer
+			putUint32Attr(&str, ethtoolAStringIndex, index)
+			putAttr(&str, ethtoolAStringValue, append([]byte(value), 0))
+			putAttr(&strs, ethtoolAStringsString|nlaFNested, str.Bytes())
+		}
+		var set bytes.Buffer
+		putUint32Attr(&set, ethtoolAStringsetID, id)
+		putAttr(&set, ethtoolAStringsetStrings|nlaFNested, strs.Bytes())
+		return set.Bytes()
+	}
+
+	var sets bytes.Buffer
+	putAttr(&sets, ethtoolAStringsetsSet|nlaFNested, stringSet(1, map[uint32]string{0: "rx_packets"}))
+	putAttr(&sets, ethtoolAStringsetsSet|nlaFNested, stringSet(ethtoolStringsetFeatures, map[uint32]string{
+		0: "tx-scatter-gather",
+		2: "tx-tcp-ecn-segmentation",
+	}))
+
+	got := parseStringSet(sets.Bytes(), ethtoolStringsetFeatures)
+	if want := []string{"tx-scatter-gather", "", "tx-tcp-ecn-segmentation"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseStringSet = %q, want %q", got, want)
+	}
+}
+
+func TestFeatureStates(t *testing.T) {
+	names := []string{"rx-gro", "", "tx-tcp-ecn-segmentation", "rx-lro", "netns-local"}
+	hw := map[string]bool{"rx-gro": true, "rx-lro": true}
+	active := map[string]bool{"rx-gro": true, "netns-local": true, "tx-checksum-ipv4": true}
+	nochange := map[string]bool{"netns-local": true}
+
+	want := map[string]Feature{
+		"rx-gro":                  {Enabled: true},
+		"rx-lro":                  {Enabled: false},
+		"tx-tcp-ecn-segmentation": {Enabled: false, Fixed: true}, // off and fixed: in no bitset
+		"netns-local":             {Enabled: true, Fixed: true},
+		"tx-checksum-ipv4":        {Enabled: true, Fixed: true}, // not in names
+	}
+	if got := featureStates(names, hw, active, nochange); !reflect.DeepEqual(got, want) {
+		t.Errorf("featureStates = %+v, want %+v", got, want)
+	}
+}
+
+// requestDevice returns the device named in the header of an ethtool
+// netlink message
+func requestDevice(msg []byte) string {
+	attrs := parseAttrs(msg[syscall.NLMSG_HDRLEN+genlHdrLen:])
+	return cString(parseAttrs(attrs[ethtoolARingsHeader])[ethtoolAHeaderDevName])
+}
+
+func TestRequestBlockedNIC(t *testing.T) {
+	blocked := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+
+	// eth0's driver never returns from its ring op
+	c := &netlinkClient{timeout: time.Minute, exchange: func(msg []byte) ([][]byte, error) {
+		if requestDevice(msg) == "eth0" {
+			blocked <- struct{}{}
+			<-release
+			return nil, syscall.EIO
+		}
+		var reply bytes.Buffer
+		putUint32Attr(&reply, ethtoolARingsRX, 1024)
+		return [][]byte{reply.Bytes()}, nil
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := c.getRings(ctx, "eth0")
+		errc <- err
+	}()
+	<-blocked
+
+	// Requests for other NICs do not queue behind the blocked one
+	for _, name := range []string{"eth1", "eth2"} {
+		cur, _, err := c.getRings(context.Background(), name)
+		if err != nil || cur.RX != 1024 {
+			t.Errorf("getRings(%s) = %+v, %v while eth0 is blocked", name, cur, err)
+		}
+	}
+
+	// Cancelling the caller abandons the blocked request
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("blocked getRings(eth0) = %v, want context canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked getRings(eth0) did not return after cancel")
+	}
+
+	// The request timeout abandons it as well
+	c.timeout = 10 * time.Millisecond
+	if _, _, err := c.getRings(context.Background(), "eth0"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("blocked getRings(eth0) = %v, want deadline exceeded", err)
+	}
+}
@@ -0,0 +1,82 @@
+//go:build !linux
+
+package system
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errNetlinkUnsupported = errors.New("ethtool netlink is only supported on linux")
+
+// netlinkClient is unavailable outside linux
+type netlinkClient struct{}
+
+func newNetlinkClient(timeout time.Duration) (*netlinkClient, error) {
+	return nil, errNetlinkUnsupported
+}
+
+func (c *netlinkClient) getLinkSpeed(ctx context.Context, name string) (LinkSpeed, error) {
+	return LinkSpeed{}, errNetlinkUnsupported
+}
+
+func (c *netlinkClient) getRings(ctx context.Context, name string) (cur, max RingParams, err error) {
+	return RingParams{}, RingParams{}, errNetlinkUnsupported
+}
+
+func (c *netlinkClient) setRings(ctx context.Context, name string, p RingParams) error {
+	return errNetlinkUnsupported
+}
+
+func (c *netlinkClient) getChannels(ctx context.Context, name string) (cur, max Channels, err error) {
+	return Channels{}, Channels{}, errNetlinkUnsupported
+}
+
+func (c *netlinkClient) setChannels(ctx context.Context, name string, ch Channels) error {
+	return errNetlinkUnsupported
+}
+
+func (c *netlinkClient) getCoalesce(ctx context.Context, name string) (Coalesce, error) {
+	return Coalesce{}, errNetlinkUnsupported
+}
+
+func (c *netlinkClient) setCoalesce(ctx context.Context, name string, co Coalesce) error {
+	return errNetlinkUnsupported
+}
+
+func (c *netlinkClient) getPause(ctx context.Context, name string) (PauseParams, error) {
+	return PauseParams{}, errNetlinkUnsupported
+}
+
+func (c *netlinkClient) setPause(ctx context.Context, name string, p PauseParams) error {
+	return errNetlinkUnsupported
+}
+
+func (c *netlinkClient) getFeatures(ctx context.Context, name string) (map[string]Feature, error) {
+	return nil, errNetlinkUnsupported
+}
+
+func (c *netlinkClient) setFeatures(ctx context.Context, name string, features map[string]bool) error {
+	return errNetlinkUnsupported
+}
+
+func (c *netlinkClient) getPrivFlags(ctx context.Context, name string) (map[string]bool, error) {
+	return nil, errNetlinkUnsupported
+}
+
+func (c *netlinkClient) setPrivFlags(ctx context.Context, name string, flags map[string]bool) error {
+	return errNetlinkUnsupported
+}
+
+func (c *netlinkClient) getFEC(ctx context.Context, name string) (FECParams, error) {
+	return FECParams{}, errNetlinkUnsupported
+}
+
+func (c *netlinkClient) setFEC(ctx context.Context, name string, encoding string) error {
+	return errNetlinkUnsupported
+}
+
+func (c *netlinkClient) driverInfo(ctx context.Context, name string) (DriverInfo, error) {
+	return DriverInfo{}, errNetlinkUnsupported
+}
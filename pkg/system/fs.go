@@ -0,0 +1,188 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxSnapshotFileSize skips sysfs attributes that are not plain values
+const maxSnapshotFileSize = 1 << 20
+
+// FS reads sysfs relative to a root directory, so that the
+// host's filesystem can be mounted elsewhere (e.g. /host in a container)
+// or replaced by a snapshot. All paths passed to FS are absolute paths as
+// seen on the host.
+type FS struct {
+	root string
+}
+
+// NewFS creates an FS rooted at root ("" or "/" is the real root)
+func NewFS(root string) *FS {
+	if root == "" {
+		root = "/"
+	}
+	return &FS{root: filepath.Clean(root)}
+}
+
+// Root returns the root directory
+func (f *FS) Root() string {
+	return f.root
+}
+
+// Path returns the real path of a host path
+func (f *FS) Path(name string) string {
+	return filepath.Join(f.root, name)
+}
+
+// ReadFile reads a file
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(f.Path(name))
+}
+
+// WriteFile writes a sysfs attribute
+func (f *FS) WriteFile(name string, data []byte) error {
+	return os.WriteFile(f.Path(name), data, 0644)
+}
+
+// ReadString reads a file and trims surrounding whitespace
+func (f *FS) ReadString(name string) (string, error) {
+	data, err := f.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ReadDir lists a directory
+func (f *FS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(f.Path(name))
+}
+
+// Stat returns file info, following symlinks
+func (f *FS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(f.Path(name))
+}
+
+// Exists reports whether a path exists
+func (f *FS) Exists(name string) bool {
+	_, err := f.Stat(name)
+	return err == nil
+}
+
+// Readlink returns the target of a symlink. Sysfs links are relative, so
+// the target stays valid under any root.
+func (f *FS) Readlink(name string) (string, error) {
+	return os.Readlink(f.Path(name))
+}
+
+// Resolve returns the host path of name with all symlinks resolved
+func (f *FS) Resolve(name string) (string, error) {
+	real, err := filepath.EvalSymlinks(f.Path(name))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(f.root, real)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s resolves outside of %s", name, f.root)
+	}
+	return path.Join("/", filepath.ToSlash(rel)), nil
+}
+
+// Snapshot copies the host path into dst, preserving symlinks. Directories
+// are copied depth levels deep, and symlinks found within that depth are
+// followed so that the tree they point to is copied as well.
+//
+// Attributes the host refuses to read (write-only or unsupported ones) are
+// left out, as reads under the snapshot then fail the same way; they are
+// returned in unreadable. Every other path that could not be copied is
+// reported in err, so an incomplete snapshot is never taken for a full one.
+func (f *FS) Snapshot(dst string, name string, depth int) (unreadable []string, err error) {
+	s := &snapshot{fs: f, dst: dst, copied: make(map[string]int)}
+	if err := s.copy(path.Clean(name), depth); err != nil {
+		s.errs = append(s.errs, err)
+	}
+	return s.unreadable, errors.Join(s.errs...)
+}
+
+type snapshot struct {
+	fs         *FS
+	dst        string
+	copied     map[string]int // deepest copy of each path
+	unreadable []string       // attributes the host cannot read
+	errs       []error        // paths that could not be copied
+}
+
+func (s *snapshot) copy(name string, depth int) error {
+	if d, ok := s.copied[name]; ok && d >= depth {
+		return nil
+	}
+	s.copied[name] = depth
+
+	info, err := os.Lstat(s.fs.Path(name))
+	if os.IsNotExist(err) {
+		// Dangling on the host too, or removed while copying
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(s.dst, name)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := s.fs.Readlink(name)
+		if err != nil {
+			return err
+		}
+		if path.IsAbs(link) {
+			link, _ = filepath.Rel(path.Dir(name), link)
+		}
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		}
+		if depth > 0 {
+			if err := s.copy(path.Join(path.Dir(name), link), depth); err != nil {
+				s.errs = append(s.errs, err)
+			}
+		}
+
+	case info.IsDir():
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		if depth == 0 {
+			return nil
+		}
+		entries, err := s.fs.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := s.copy(path.Join(name, entry.Name()), depth-1); err != nil {
+				s.errs = append(s.errs, err)
+			}
+		}
+
+	case info.Mode().IsRegular():
+		if info.Size() > maxSnapshotFileSize {
+			return fmt.Errorf("%s is too large to snapshot", name)
+		}
+		data, err := s.fs.ReadFile(name)
+		if err != nil {
+			s.unreadable = append(s.unreadable, name)
+			return nil
+		}
+		return os.WriteFile(target, data, 0644)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runner executes external commands and returns their combined output.
+// Cancelling ctx kills a running command.
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// ExecRunner runs commands on the local host
+type ExecRunner struct {
+	// Timeout kills commands that run longer, e.g. ethtool stuck on a
+	// wedged driver (0 = no timeout)
+	Timeout time.Duration
+}
+
+// Run executes the command and returns its combined output
+func (r ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmdCtx := ctx
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	out, err := exec.CommandContext(cmdCtx, name, args...).CombinedOutput()
+	if err != nil && ctx.Err() != nil {
+		return out, fmt.Errorf("%s: %w", commandKey(name, args), ctx.Err())
+	}
+	if err != nil && cmdCtx.Err() == context.DeadlineExceeded {
+		return out, fmt.Errorf("%s timed out after %v", commandKey(name, args), r.Timeout)
+	}
+	return out, err
+}
+
+// Fixture is a set of recorded command invocations
+type Fixture struct {
+	Host     string         `json:"host,omitempty"`
+	Commands []FixtureEntry `json:"commands"`
+}
+
+// FixtureEntry is a single recorded command invocation
+type FixtureEntry struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Output  string   `json:"output"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// key identifies the invocation for replay lookups
+func (f FixtureEntry) key() string {
+	return commandKey(f.Command, f.Args)
+}
+
+func commandKey(name string, args []string) string {
+	return strings.Join(append([]string{name}, args...), " ")
+}
+
+// LoadFixture reads a fixture file
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %v", path, err)
+	}
+
+	return &fixture, nil
+}
+
+// Save writes the fixture to a file
+func (f *Fixture) Save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// RecordingRunner runs commands through another runner and records every
+// invocation to a fixture file
+type RecordingRunner struct {
+	mu      sync.Mutex
+	runner  Runner
+	path    string
+	fixture Fixture
+}
+
+// NewRecordingRunner creates a runner recording to path
+func NewRecordingRunner(runner Runner, path string) *RecordingRunner {
+	host, _ := os.Hostname()
+	return &RecordingRunner{
+		runner:  runner,
+		path:    path,
+		fixture: Fixture{Host: host},
+	}
+}
+
+// Run executes the command and appends it to the fixture file
+func (r *RecordingRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := r.runner.Run(ctx, name, args...)
+
+	entry := FixtureEntry{
+		Command: name,
+		Args:    append([]string{}, args...),
+		Output:  string(output),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fixture.Commands = append(r.fixture.Commands, entry)
+
+	// Save after every call so a crash still leaves a usable fixture
+	if saveErr := r.fixture.Save(r.path); saveErr != nil {
+		return output, fmt.Errorf("failed to record %s: %v", entry.key(), saveErr)
+	}
+
+	return output, err
+}
+
+// ReplayRunner serves command output from a fixture. Repeated invocations
+// of the same command are served in recorded order, with the last
+// recording repeated once the sequence is exhausted.
+type ReplayRunner struct {
+	mu      sync.Mutex
+	entries map[string][]FixtureEntry
+}
+
+// NewReplayRunner creates a runner replaying the given fixture
+func NewReplayRunner(fixture *Fixture) *ReplayRunner {
+	r := &ReplayRunner{entries: make(map[string][]FixtureEntry)}
+	for _, entry := range fixture.Commands {
+		r.entries[entry.key()] = append(r.entries[entry.key()], entry)
+	}
+	return r
+}
+
+// Run returns the recorded output for the command
+func (r *ReplayRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	key := commandKey(name, args)
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := r.entries[key]
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no recorded output for %q", key)
+	}
+
+	entry := entries[0]
+	if len(entries) > 1 {
+		r.entries[key] = entries[1:]
+	}
+
+	if entry.Error != "" {
+		return []byte(entry.Output), errors.New(entry.Error)
+	}
+	return []byte(entry.Output), nil
+}
@@ -0,0 +1,100 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeRunner returns canned output for every command
+type fakeRunner map[string]string
+
+func (f fakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, ok := f[commandKey(name, args)]
+	if !ok {
+		return []byte("no such device\n"), errors.New("exit status 1")
+	}
+	return []byte(output), nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	recorder := NewRecordingRunner(fakeRunner{"ethtool -i eth0": "driver: mlx5_core\n"}, path)
+
+	if _, err := recorder.Run(ctx, "ethtool", "-i", "eth0"); err != nil {
+		t.Fatalf("recording ethtool -i eth0: %v", err)
+	}
+	if _, err := recorder.Run(ctx, "ethtool", "-i", "eth1"); err == nil {
+		t.Fatal("recording ethtool -i eth1 succeeded")
+	}
+
+	fixture, err := LoadFixture(path)
+	if err != nil {
+		t.Fatalf("LoadFixture: %v", err)
+	}
+	if len(fixture.Commands) != 2 {
+		t.Fatalf("recorded %d commands, want 2", len(fixture.Commands))
+	}
+
+	replay := NewReplayRunner(fixture)
+	output, err := replay.Run(ctx, "ethtool", "-i", "eth0")
+	if err != nil || string(output) != "driver: mlx5_core\n" {
+		t.Errorf("replaying ethtool -i eth0 = %q, %v", output, err)
+	}
+	output, err = replay.Run(ctx, "ethtool", "-i", "eth1")
+	if err == nil || err.Error() != "exit status 1" || string(output) != "no such device\n" {
+		t.Errorf("replaying ethtool -i eth1 = %q, %v, want the recorded failure", output, err)
+	}
+	if _, err := replay.Run(ctx, "ethtool", "-g", "eth0"); err == nil {
+		t.Error("replaying an unrecorded command succeeded")
+	}
+}
+
+func TestReplayOrder(t *testing.T) {
+	ctx := context.Background()
+	replay := NewReplayRunner(&Fixture{Commands: []FixtureEntry{
+		{Command: "ethtool", Args: []string{"-g", "eth0"}, Output: "first"},
+		{Command: "ethtool", Args: []string{"-g", "eth0"}, Output: "second"},
+	}})
+
+	// Recordings are served in order, the last one repeated
+	for _, want := range []string{"first", "second", "second"} {
+		output, err := replay.Run(ctx, "ethtool", "-g", "eth0")
+		if err != nil || string(output) != want {
+			t.Errorf("Run = %q, %v, want %q", output, err, want)
+		}
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	replay := NewReplayRunner(&Fixture{Commands: []FixtureEntry{
+		{Command: "ethtool", Args: []string{"-g", "eth0"}, Output: "first"},
+	}})
+	if _, err := replay.Run(ctx, "ethtool", "-g", "eth0"); !errors.Is(err, context.Canceled) {
+		t.Errorf("replaying with a cancelled context = %v", err)
+	}
+}
+
+func TestExecRunnerKilledOnCancel(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not found")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := ExecRunner{}.Run(ctx, "sleep", "30")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run = %v, want the context error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("sleep was not killed, Run took %v", elapsed)
+	}
+}
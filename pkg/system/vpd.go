@@ -0,0 +1,97 @@
+package system
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// PCI VPD resource tags (PCI Local Bus Specification 3.0, Appendix I)
+const (
+	vpdTagIDString = 0x02 // large resource: product name
+	vpdTagReadOnly = 0x10 // large resource: VPD-R keywords
+	vpdTagWritable = 0x11 // large resource: VPD-W keywords
+	vpdTagEnd      = 0x0f // small resource: end of VPD
+)
+
+// VPD holds the Vital Product Data of a PCI device
+type VPD struct {
+	ProductName string
+	// Keywords from the read-only and writable sections, e.g. PN, SN, EC
+	Fields map[string]string
+}
+
+// PartNumber returns the board part number (PN keyword)
+func (v VPD) PartNumber() string {
+	return v.Fields["PN"]
+}
+
+// SerialNumber returns the board serial number (SN keyword)
+func (v VPD) SerialNumber() string {
+	return v.Fields["SN"]
+}
+
+// ParseVPD parses the contents of /sys/bus/pci/devices/<bdf>/vpd
+func ParseVPD(data []byte) (VPD, error) {
+	vpd := VPD{Fields: make(map[string]string)}
+
+	for len(data) > 0 {
+		tag := data[0]
+		if tag&0x80 == 0 {
+			// Small resource: name in bits 6:3, length in bits 2:0
+			if (tag>>3)&0x0f == vpdTagEnd {
+				break
+			}
+			length := int(tag & 0x07)
+			if 1+length > len(data) {
+				return vpd, fmt.Errorf("truncated VPD resource 0x%02x", tag)
+			}
+			data = data[1+length:]
+			continue
+		}
+
+		// Large resource: name in bits 6:0, 16-bit little-endian length
+		if len(data) < 3 {
+			return vpd, fmt.Errorf("truncated VPD resource 0x%02x", tag)
+		}
+		length := int(binary.LittleEndian.Uint16(data[1:3]))
+		if 3+length > len(data) {
+			return vpd, fmt.Errorf("truncated VPD resource 0x%02x", tag)
+		}
+		body := data[3 : 3+length]
+		data = data[3+length:]
+
+		switch tag & 0x7f {
+		case vpdTagIDString:
+			vpd.ProductName = vpdString(body)
+		case vpdTagReadOnly, vpdTagWritable:
+			parseVPDKeywords(body, vpd.Fields)
+		}
+	}
+
+	if vpd.ProductName == "" && len(vpd.Fields) == 0 {
+		return vpd, fmt.Errorf("no VPD found")
+	}
+	return vpd, nil
+}
+
+// parseVPDKeywords parses the keyword list of a VPD-R or VPD-W section.
+// The RV checksum and RW scratch keywords are skipped.
+func parseVPDKeywords(body []byte, fields map[string]string) {
+	for len(body) >= 3 {
+		key := string(body[0:2])
+		length := int(body[2])
+		if 3+length > len(body) {
+			return
+		}
+		if key != "RV" && key != "RW" {
+			fields[key] = vpdString(body[3 : 3+length])
+		}
+		body = body[3+length:]
+	}
+}
+
+// vpdString trims the NUL and space padding of a VPD value
+func vpdString(b []byte) string {
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
@@ -0,0 +1,79 @@
+package system
+
+import (
+	"testing"
+)
+
+// vpdResource encodes a large VPD resource
+func vpdResource(tag byte, body []byte) []byte {
+	return append([]byte{tag, byte(len(body)), byte(len(body) >> 8)}, body...)
+}
+
+// vpdKeyword encodes a VPD-R or VPD-W keyword
+func vpdKeyword(key, value string) []byte {
+	return append([]byte{key[0], key[1], byte(len(value))}, value...)
+}
+
+func TestParseVPD(t *testing.T) {
+	var readOnly []byte
+	readOnly = append(readOnly, vpdKeyword("PN", "MCX755106AS-HEAT ")...)
+	readOnly = append(readOnly, vpdKeyword("SN", "MT2312X00001\x00\x00")...)
+	readOnly = append(readOnly, vpdKeyword("RV", "\x5a")...)
+
+	var full []byte
+	full = append(full, vpdResource(0x82, []byte("ConnectX-7 VPI adapter card"))...)
+	full = append(full, vpdResource(0x90, readOnly)...)
+	full = append(full, vpdResource(0x91, vpdKeyword("V1", "custom"))...)
+	full = append(full, 0x78) // end tag
+
+	tests := []struct {
+		name    string
+		data    []byte
+		product string
+		pn, sn  string
+		fields  int
+		wantErr bool
+	}{
+		{
+			name:    "full",
+			data:    full,
+			product: "ConnectX-7 VPI adapter card",
+			pn:      "MCX755106AS-HEAT",
+			sn:      "MT2312X00001",
+			fields:  3, // PN, SN, V1; RV is skipped
+		},
+		{
+			name:    "product name only",
+			data:    append(vpdResource(0x82, []byte("NIC")), 0x78),
+			product: "NIC",
+		},
+		{
+			name:    "truncated",
+			data:    vpdResource(0x82, []byte("ConnectX-7"))[:6],
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			data:    []byte{0x78},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpd, err := ParseVPD(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVPD error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if vpd.ProductName != tt.product || vpd.PartNumber() != tt.pn || vpd.SerialNumber() != tt.sn {
+				t.Errorf("ParseVPD = %q, PN %q, SN %q", vpd.ProductName, vpd.PartNumber(), vpd.SerialNumber())
+			}
+			if len(vpd.Fields) != tt.fields {
+				t.Errorf("ParseVPD fields = %v, want %d", vpd.Fields, tt.fields)
+			}
+		})
+	}
+}
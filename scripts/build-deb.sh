@@ -72,6 +72,9 @@ set -e
 if [ "\$1" = "remove" ]; then
     systemctl stop ${PKG_NAME}.service || true
     systemctl disable ${PKG_NAME}.service || true
+
+    # Put every changed NIC back to its original settings
+    /usr/local/bin/${PKG_NAME} -restore -log /var/log/${PKG_NAME}/${PKG_NAME}.log || true
 fi
 EOF
 